@@ -0,0 +1,93 @@
+package cpio
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchivePaths writes an archive of the files at paths to w in enc, like
+// `cpio -o` given a list of names. If recursive is set, the contents of
+// directories are included, in lexical order.
+//
+// Entries are named relative to the directory containing each path, with
+// forward slashes, so "/etc" is archived as "etc", "etc/passwd" and so on,
+// and "../src/main.go" as "main.go". Symlinks are stored with their target
+// as data rather than followed, and hard links are stored as separate
+// copies. On Linux and macOS the owner and device numbers are recorded.
+func ArchivePaths(w io.Writer, enc EncodingType, paths []string, recursive bool) error {
+	return ArchivePathsContext(context.Background(), w, enc, paths, recursive)
+}
+
+// ArchivePathsContext is like ArchivePaths, but stops with the context's
+// error if ctx is done before the next file is written. The archive is left
+// without a trailer, as for any other error.
+func ArchivePathsContext(ctx context.Context, w io.Writer, enc EncodingType, paths []string, recursive bool) error {
+	cw := NewWriterEncoding(w, enc)
+	for _, p := range paths {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		base := filepath.Dir(filepath.Clean(p))
+		if recursive {
+			err = filepath.Walk(p, func(name string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+				return archivePath(cw, enc, base, name, fi)
+			})
+		} else {
+			var fi os.FileInfo
+			fi, err = os.Lstat(p)
+			if err == nil {
+				err = archivePath(cw, enc, base, p, fi)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
+
+// archivePath writes the entry for the file at name, named relative to base
+func archivePath(cw *Writer, enc EncodingType, base, name string, fi os.FileInfo) error {
+	hdr, err := FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(base, name)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	hdr.Encoding = enc
+	hdr.NLink = 1
+	statHeader(hdr, fi)
+
+	switch mode := fi.Mode(); {
+	case mode.IsRegular():
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return cw.AddReader(hdr, f)
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(name)
+		if err != nil {
+			return err
+		}
+		hdr.Size = int64(len(target))
+		return cw.AddReader(hdr, strings.NewReader(target))
+	default:
+		hdr.Size = 0
+		return cw.WriteHeaderOnly(hdr)
+	}
+}
@@ -0,0 +1,88 @@
+package cpio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchivePaths(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "root", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "root", "a.txt"), []byte("hello"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "root", "sub", "b.txt"), []byte("world"), 0600)
+	os.Symlink("a.txt", filepath.Join(dir, "root", "link"))
+	root := filepath.Join(dir, "root")
+
+	read := func(data []byte) map[string]string {
+		entries := make(map[string]string)
+		err := NewReader(bytes.NewReader(data)).ForEach(func(hdr *Header, body io.Reader) error {
+			b, err := ioutil.ReadAll(body)
+			entries[hdr.Name] = hdr.FileInfo().Mode().String() + " " + string(b)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	buf := new(bytes.Buffer)
+	if err := ArchivePaths(buf, EncodingTypeASCIISVR4, []string{root}, true); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"root":           "drwxr-xr-x ",
+		"root/a.txt":     "-rw-r--r-- hello",
+		"root/link":      "Lrwxrwxrwx a.txt",
+		"root/sub":       "drwxr-xr-x ",
+		"root/sub/b.txt": "-rw------- world",
+	}
+	entries := read(buf.Bytes())
+	intEq(t, "entries", len(expected), len(entries))
+	for name, entry := range expected {
+		if entries[name] != entry {
+			t.Errorf("%s: expected %q but got %q", name, entry, entries[name])
+		}
+	}
+
+	buf.Reset()
+	if err := ArchivePaths(buf, EncodingTypeASCIISUSv2, []string{root, filepath.Join(root, "a.txt")}, false); err != nil {
+		t.Fatal(err)
+	}
+	entries = read(buf.Bytes())
+	intEq(t, "entries without recursion", 2, len(entries))
+	if entries["a.txt"] != "-rw-r--r-- hello" {
+		t.Errorf("expected a.txt to be named relative to its directory but got %q", entries)
+	}
+
+	// a relative path climbing out of the working directory
+	t.Chdir(filepath.Join(root, "sub"))
+	buf.Reset()
+	if err := ArchivePaths(buf, EncodingTypeASCIISVR4, []string{"../sub"}, true); err != nil {
+		t.Fatal(err)
+	}
+	entries = read(buf.Bytes())
+	if len(entries) != 2 || entries["sub/b.txt"] != "-rw------- world" {
+		t.Errorf("expected sub and sub/b.txt but got %q", entries)
+	}
+
+	if err := ArchivePaths(ioutil.Discard, EncodingTypeASCIISVR4, []string{filepath.Join(dir, "missing")}, true); !os.IsNotExist(err) {
+		t.Error("expected a not exist error but got:", err)
+	}
+}
+
+func TestArchivePathsContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf := new(bytes.Buffer)
+	err := ArchivePathsContext(ctx, buf, EncodingTypeASCIISVR4, []string{t.TempDir()}, true)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled but got:", err)
+	}
+	intEq(t, "bytes written", 0, buf.Len())
+}
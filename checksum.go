@@ -0,0 +1,46 @@
+package cpio
+
+import "hash"
+
+// Checksum returns the checksum of data as stored in the header of a "crc"
+// format entry.
+//
+// Despite the format's name it is not a CRC. Each byte of data is read as
+// an unsigned value from 0 to 255, and the values are summed in a 32-bit
+// unsigned integer that wraps on overflow, so the result is the sum modulo
+// 2^32. This matches GNU cpio's -H crc and libarchive. Only the entry's
+// data is summed, not its header, name or padding.
+func Checksum(data []byte) uint32 {
+	return sumBytes(0, data)
+}
+
+// NewChecksum returns a hash.Hash32 computing Checksum, such as for
+// Reader.Hash to verify entries as they are read. Sum appends the checksum
+// in big-endian byte order.
+func NewChecksum() hash.Hash32 {
+	return new(checksum)
+}
+
+type checksum uint32
+
+func (c *checksum) Write(b []byte) (int, error) {
+	*c = checksum(sumBytes(uint32(*c), b))
+	return len(b), nil
+}
+
+func (c *checksum) Sum(b []byte) []byte {
+	return append(b, byte(*c>>24), byte(*c>>16), byte(*c>>8), byte(*c))
+}
+
+func (c *checksum) Reset()         { *c = 0 }
+func (c *checksum) Size() int      { return 4 }
+func (c *checksum) BlockSize() int { return 1 }
+func (c *checksum) Sum32() uint32  { return uint32(*c) }
+
+// sumBytes adds each byte of b to sum, as used by the "crc" format
+func sumBytes(sum uint32, b []byte) uint32 {
+	for _, c := range b {
+		sum += uint32(c)
+	}
+	return sum
+}
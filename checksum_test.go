@@ -0,0 +1,41 @@
+package cpio
+
+import (
+	"bytes"
+	"hash"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	f, err := os.Open("test-data/ascii-svr4-crc.cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := NewReader(f)
+	r.Hash = func() hash.Hash { return NewChecksum() }
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "Checksum", 562, int(Checksum(data)))
+	if sum := r.EntryDigest(); !bytes.Equal(sum, []byte{0, 0, 2, 0x32}) {
+		t.Errorf("expected digest to be 00000232 but got %x", sum)
+	}
+	if Checksum(data) != hdr.Checksum {
+		t.Errorf("expected Checksum to match header value %d but got %d", hdr.Checksum, Checksum(data))
+	}
+
+	// bytes are unsigned, and the sum wraps at 32 bits
+	intEq(t, "Checksum", 0x80+0xff, int(Checksum([]byte{0x80, 0xff})))
+	if sum := sumBytes(0xfffffffe, []byte{0xff}); sum != 0xfd {
+		t.Errorf("expected sum to wrap to 0xfd but got %#x", sum)
+	}
+}
@@ -0,0 +1,37 @@
+package cpio
+
+import (
+	"strings"
+	"time"
+)
+
+// CommentEntryName is the name of the entry written by WriteComment, unless
+// Writer.CommentName is set.
+const CommentEntryName = ".comment"
+
+// WriteComment writes text as the data of a regular file entry named
+// CommentEntryName, or Writer.CommentName if set, in the encoding of the
+// archive's earlier entries, or odc if there are none. Extractors see an
+// ordinary file, while inspectors can find it with IsComment. It is written
+// like any other entry, so it must not be called while a file's data is
+// pending.
+func (cw *Writer) WriteComment(text string) error {
+	name := cw.CommentName
+	if name == "" {
+		name = CommentEntryName
+	}
+	return cw.AddReader(&Header{
+		Encoding: cw.enc,
+		Name:     name,
+		Mode:     0100644,
+		NLink:    1,
+		ModTime:  time.Unix(0, 0),
+		Size:     int64(len(text)),
+	}, strings.NewReader(text))
+}
+
+// IsComment reports whether hdr is a comment entry written by WriteComment
+// with the default name.
+func IsComment(hdr *Header) bool {
+	return hdr.Mode&^07777 == modeRegular && hdr.Name == CommentEntryName
+}
@@ -0,0 +1,47 @@
+package cpio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteComment(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	if err := w.WriteComment("built by make initramfs"); err != nil {
+		t.Fatal(err)
+	}
+	w.CommentName = "notes.txt"
+	if err := w.WriteComment("custom"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r := NewReader(buf)
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsComment(hdr) {
+		t.Errorf("expected %q to be a comment", hdr.Name)
+	}
+	body, _ := ioutil.ReadAll(r)
+	if string(body) != "built by make initramfs" {
+		t.Errorf("expected the comment text but got %q", body)
+	}
+
+	hdr, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "notes.txt" {
+		t.Errorf("expected name to be notes.txt but got %q", hdr.Name)
+	}
+	if IsComment(hdr) {
+		t.Error("expected a custom named comment not to match IsComment")
+	}
+	if IsComment(&Header{Name: CommentEntryName, Mode: 040755}) {
+		t.Error("expected a directory not to be a comment")
+	}
+}
@@ -0,0 +1,202 @@
+package cpio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// DiffKind describes how an entry differs between two archives
+type DiffKind int
+
+// Kinds of differences reported by Diff
+const (
+	// DiffOnlyInA means the entry is only present in the first archive
+	DiffOnlyInA DiffKind = iota
+
+	// DiffOnlyInB means the entry is only present in the second archive
+	DiffOnlyInB
+
+	// DiffHeader means the mode, mtime, uid, gid or size of the entry differ
+	DiffHeader
+
+	// DiffContent means the body of the entry differs
+	DiffContent
+)
+
+// DiffEntry is a single difference between two archives
+type DiffEntry struct {
+	Name string   // name of the entry
+	Kind DiffKind // kind of difference
+	A    *Header  // header from the first archive, nil if not present
+	B    *Header  // header from the second archive, nil if not present
+}
+
+type diffInfo struct {
+	hdr *Header
+	sum []byte
+}
+
+// readDiffInfo reads every entry of an archive, hashing each body as it
+// streams through so that no body is held in memory.
+func readDiffInfo(r io.Reader) (map[string]diffInfo, error) {
+	cr := NewReader(r)
+	entries := make(map[string]diffInfo)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, cr)
+		if err != nil {
+			return nil, err
+		}
+		entries[hdr.Name] = diffInfo{hdr: hdr, sum: h.Sum(nil)}
+	}
+}
+
+// Diff reads two archives and reports the differences between them,
+// matching entries by name. The result is sorted by name.
+//
+// An entry whose header and body both differ is reported twice, once as
+// DiffHeader and once as DiffContent. If a name appears more than once in
+// an archive, the last entry is used.
+func Diff(a, b io.Reader) ([]DiffEntry, error) {
+	infoA, err := readDiffInfo(a)
+	if err != nil {
+		return nil, err
+	}
+	infoB, err := readDiffInfo(b)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infoA)+len(infoB))
+	for name := range infoA {
+		names = append(names, name)
+	}
+	for name := range infoB {
+		if _, ok := infoA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []DiffEntry
+	for _, name := range names {
+		ia, okA := infoA[name]
+		ib, okB := infoB[name]
+		switch {
+		case !okB:
+			diffs = append(diffs, DiffEntry{Name: name, Kind: DiffOnlyInA, A: ia.hdr})
+			continue
+		case !okA:
+			diffs = append(diffs, DiffEntry{Name: name, Kind: DiffOnlyInB, B: ib.hdr})
+			continue
+		}
+
+		if ia.hdr.Mode != ib.hdr.Mode ||
+			!ia.hdr.ModTime.Equal(ib.hdr.ModTime) ||
+			ia.hdr.UID != ib.hdr.UID ||
+			ia.hdr.GID != ib.hdr.GID ||
+			ia.hdr.Size != ib.hdr.Size {
+			diffs = append(diffs, DiffEntry{Name: name, Kind: DiffHeader, A: ia.hdr, B: ib.hdr})
+		}
+		if !bytes.Equal(ia.sum, ib.sum) {
+			diffs = append(diffs, DiffEntry{Name: name, Kind: DiffContent, A: ia.hdr, B: ib.hdr})
+		}
+	}
+
+	return diffs, nil
+}
+
+// EquivalentContent reads two archives, which may use different encodings,
+// and reports whether they hold the same files: the same names, each with
+// the same mode, device numbers for devices, and body. Fields whose
+// representation depends on the encoding, or that are usually incidental,
+// such as inodes, ownership and ModTime, are ignored.
+//
+// Hard links, entries sharing a device and inode with an NLink above 1, are
+// compared by the data of their group, as newc stores it with only one link
+// while odc repeats it for each.
+//
+// Each difference found is described in the returned list, sorted by name.
+func EquivalentContent(a, b io.Reader) (bool, []string, error) {
+	infoA, err := readDiffInfo(a)
+	if err != nil {
+		return false, nil, err
+	}
+	infoB, err := readDiffInfo(b)
+	if err != nil {
+		return false, nil, err
+	}
+	shareLinkData(infoA)
+	shareLinkData(infoB)
+
+	names := make([]string, 0, len(infoA)+len(infoB))
+	for name := range infoA {
+		names = append(names, name)
+	}
+	for name := range infoB {
+		if _, ok := infoA[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []string
+	for _, name := range names {
+		ia, okA := infoA[name]
+		ib, okB := infoB[name]
+		switch {
+		case !okB:
+			diffs = append(diffs, name+": only in first archive")
+			continue
+		case !okA:
+			diffs = append(diffs, name+": only in second archive")
+			continue
+		}
+
+		if ia.hdr.Mode != ib.hdr.Mode {
+			diffs = append(diffs, fmt.Sprintf("%s: mode %o != %o", name, ia.hdr.Mode, ib.hdr.Mode))
+			continue
+		}
+		switch ia.hdr.Mode &^ 07777 {
+		case modeCharDev, modeBlkDev:
+			if ia.hdr.RDevMajor != ib.hdr.RDevMajor || ia.hdr.RDevMinor != ib.hdr.RDevMinor {
+				diffs = append(diffs, fmt.Sprintf("%s: device %d, %d != %d, %d", name, ia.hdr.RDevMajor, ia.hdr.RDevMinor, ib.hdr.RDevMajor, ib.hdr.RDevMinor))
+			}
+		}
+		if !bytes.Equal(ia.sum, ib.sum) {
+			diffs = append(diffs, name+": content differs")
+		}
+	}
+
+	return len(diffs) == 0, diffs, nil
+}
+
+// shareLinkData gives each empty hard link in entries the data of another
+// link in its group that has some
+func shareLinkData(entries map[string]diffInfo) {
+	sums := make(map[linkKey][]byte)
+	for _, e := range entries {
+		if e.hdr.NLink > 1 && e.hdr.Inode != 0 && e.hdr.Size > 0 {
+			sums[linkKey{e.hdr.DevMajor, e.hdr.DevMinor, e.hdr.Inode}] = e.sum
+		}
+	}
+	for name, e := range entries {
+		if e.hdr.NLink <= 1 || e.hdr.Inode == 0 || e.hdr.Size > 0 {
+			continue
+		}
+		if sum, ok := sums[linkKey{e.hdr.DevMajor, e.hdr.DevMinor, e.hdr.Inode}]; ok {
+			e.sum = sum
+			entries[name] = e
+		}
+	}
+}
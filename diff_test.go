@@ -0,0 +1,107 @@
+package cpio
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := testArchive(t, EncodingTypeASCIISVR4,
+		"same", "hello",
+		"changed", "before",
+		"resized", "abc",
+		"removed", "gone",
+	)
+	b := testArchive(t, EncodingTypeASCIISVR4,
+		"same", "hello",
+		"changed", "after!",
+		"resized", "abcd",
+		"added", "new",
+	)
+
+	diffs, err := Diff(bytes.NewReader(a), bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name string
+		kind DiffKind
+	}{
+		{"added", DiffOnlyInB},
+		{"changed", DiffContent},
+		{"removed", DiffOnlyInA},
+		{"resized", DiffHeader},
+		{"resized", DiffContent},
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("expected %d differences but got %d: %+v", len(expected), len(diffs), diffs)
+	}
+	for i, e := range expected {
+		if diffs[i].Name != e.name || diffs[i].Kind != e.kind {
+			t.Errorf("difference %d: expected %s/%d but got %s/%d", i, e.name, e.kind, diffs[i].Name, diffs[i].Kind)
+		}
+	}
+}
+
+func TestEquivalentContent(t *testing.T) {
+	build := func(enc EncodingType, body string, minor int) []byte {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		hdr := NewFileHeader("file", int64(len(body)), 0644)
+		hdr.Encoding = enc
+		hdr.ModTime = testModTime
+		w.AddReader(hdr, strings.NewReader(body))
+		dev := NewCharDeviceHeader("dev/tty", 4, minor, 0620)
+		dev.Encoding = enc
+		dev.ModTime = testModTime
+		w.WriteHeaderOnly(dev)
+		w.Close()
+		return buf.Bytes()
+	}
+
+	ok, diffs, err := EquivalentContent(bytes.NewReader(build(EncodingTypeASCIISVR4, "hello", 1)), bytes.NewReader(build(EncodingTypeASCIISUSv2, "hello", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || len(diffs) != 0 {
+		t.Errorf("expected newc and odc archives to be equivalent but got %q", diffs)
+	}
+
+	ok, diffs, err = EquivalentContent(bytes.NewReader(build(EncodingTypeASCIISVR4, "hello", 1)), bytes.NewReader(build(EncodingTypeBinaryLE, "world", 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dev/tty: device 4, 1 != 4, 2", "file: content differs"}
+	if ok || !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected differences %q but got %q", expected, diffs)
+	}
+
+	// newc stores hard link data once, on the last link, where odc repeats it
+	links := func(enc EncodingType, bodies ...string) []byte {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		for i, body := range bodies {
+			w.AddReader(&Header{Encoding: enc, Name: fmt.Sprint("link", i), Mode: 0100644, Inode: 7, NLink: len(bodies), Size: int64(len(body)), ModTime: testModTime}, strings.NewReader(body))
+		}
+		w.Close()
+		return buf.Bytes()
+	}
+	ok, diffs, err = EquivalentContent(bytes.NewReader(links(EncodingTypeASCIISVR4, "", "", "hello")), bytes.NewReader(links(EncodingTypeASCIISUSv2, "hello", "hello", "hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("expected hard links to be equivalent but got %q", diffs)
+	}
+	ok, _, err = EquivalentContent(bytes.NewReader(links(EncodingTypeASCIISVR4, "", "hello")), bytes.NewReader(links(EncodingTypeASCIISUSv2, "world", "world")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected hard links with different data not to be equivalent")
+	}
+}
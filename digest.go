@@ -0,0 +1,97 @@
+package cpio
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// DigestEntryName is the name of the entry holding the archive digest
+// written by a Writer with ArchiveDigest set.
+const DigestEntryName = ".cpio-digest"
+
+var (
+	// ErrDigestMissing is returned by VerifyDigest if the archive has no
+	// digest entry.
+	ErrDigestMissing = errors.New("cpio: archive has no digest entry")
+
+	// ErrDigestMismatch is returned by VerifyDigest if the archive does not
+	// match its digest entry.
+	ErrDigestMismatch = errors.New("cpio: archive does not match its digest")
+)
+
+// VerifyDigest reads the archive from r and checks it against the digest
+// entry written by a Writer with ArchiveDigest set, using the same hash.
+// The digest entry must be the last entry before the trailer.
+func VerifyDigest(r io.Reader, h func() hash.Hash) error {
+	dr := &digestReader{r: r, h: h()}
+	cr := NewReader(dr)
+	for {
+		// the next header must not be hashed if it is the digest entry,
+		// but the padding of the previous entry read along with it must be
+		dr.hold = true
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return ErrDigestMissing
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == DigestEntryName {
+			dr.release(int64(dr.held.Len()) - headerSize(hdr.Encoding, len(hdr.Name)+1))
+			sum := hex.EncodeToString(dr.h.Sum(nil))
+			data, err := ioutil.ReadAll(cr)
+			if err != nil {
+				return err
+			}
+			if string(data) != sum {
+				return ErrDigestMismatch
+			}
+			_, err = cr.Next()
+			if err == nil {
+				return ErrDigestMismatch
+			}
+			if err != io.EOF {
+				return err
+			}
+			return nil
+		}
+
+		dr.release(int64(dr.held.Len()))
+		_, err = io.Copy(ioutil.Discard, cr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// digestReader hashes what is read through it, holding bytes back from the
+// hash while hold is set.
+type digestReader struct {
+	r    io.Reader
+	h    hash.Hash
+	hold bool
+	held bytes.Buffer
+}
+
+func (d *digestReader) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	if d.hold {
+		d.held.Write(b[:n])
+	} else {
+		d.h.Write(b[:n])
+	}
+	return n, err
+}
+
+// release hashes the first n held bytes, discards the rest, and stops holding
+func (d *digestReader) release(n int64) {
+	if n > 0 {
+		d.h.Write(d.held.Bytes()[:n])
+	}
+	d.held.Reset()
+	d.hold = false
+}
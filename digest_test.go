@@ -0,0 +1,46 @@
+package cpio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+func TestArchiveDigest(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeBinaryLE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			w.ArchiveDigest = sha256.New
+			for _, body := range []string{"odd", "even", ""} {
+				err := w.WriteHeader(&Header{Encoding: enc, Name: "f" + body, Mode: 0100644, NLink: 1, Size: int64(len(body)), ModTime: testModTime})
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.WriteString(w, body)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+
+			if err := VerifyDigest(bytes.NewReader(data), sha256.New); err != nil {
+				t.Error("verify:", err)
+			}
+
+			// flip a byte in the first body
+			bad := append([]byte{}, data...)
+			i := bytes.Index(bad, []byte("odd"))
+			bad[i] = 'O'
+			if err := VerifyDigest(bytes.NewReader(bad), sha256.New); err != ErrDigestMismatch {
+				t.Error("expected ErrDigestMismatch but got:", err)
+			}
+		})
+	}
+
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "b")
+	if err := VerifyDigest(bytes.NewReader(data), sha256.New); err != ErrDigestMissing {
+		t.Error("expected ErrDigestMissing but got:", err)
+	}
+}
@@ -0,0 +1,593 @@
+package cpio
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInsecurePath is returned by Extract for entries whose name would
+	// place them outside the destination directory.
+	ErrInsecurePath = errors.New("cpio: insecure path in archive")
+
+	// ErrInsecureSymlink is returned by Extract for symlinks whose target
+	// would point outside the destination directory, unless allowed by
+	// ExtractOptions.Symlinks.
+	ErrInsecureSymlink = errors.New("cpio: insecure symlink target in archive")
+)
+
+// SymlinkPolicy controls how Extract handles symlinks whose target is an
+// absolute path or climbs out of the destination directory with "..".
+// Targets with ".." after another element, such as "a/b/..", are treated as
+// climbing out, as a symlink along the way may lead anywhere.
+type SymlinkPolicy int
+
+// Symlink policies for ExtractOptions
+const (
+	// SymlinkReject causes ErrInsecureSymlink to be returned
+	SymlinkReject SymlinkPolicy = iota
+
+	// SymlinkRewrite rewrites the target relative to the destination
+	// directory, as if it were the root, so "/bin/sh" from "usr/bin/sh"
+	// becomes "../../bin/sh"
+	SymlinkRewrite
+
+	// SymlinkAllow creates the symlink with the target unchanged
+	SymlinkAllow
+)
+
+// ExtractOptions configures Extract
+type ExtractOptions struct {
+	// Parallelism is the number of regular files written concurrently.
+	//
+	// The archive is still read sequentially, so when Parallelism is greater
+	// than 1 each file's data is read into memory and handed to a worker,
+	// and roughly Parallelism file bodies may be held in memory at once.
+	// Directories and symlinks are always created in archive order.
+	Parallelism int
+
+	// Symlinks controls the handling of symlinks that point outside the
+	// destination directory. The default rejects them.
+	Symlinks SymlinkPolicy
+
+	// NoModTime leaves extracted files and directories with the time they
+	// were written, rather than restoring each entry's ModTime.
+	NoModTime bool
+}
+
+// Extract writes the contents of the archive read by r into dir.
+//
+// Regular files, directories and symlinks are created; devices, FIFOs and
+// sockets are skipped. Regular files sharing a device and inode, other than
+// inode 0, with an NLink greater than 1 are hard links, and are linked to the
+// one carrying the data once every entry has been extracted, as newc archives
+// only store it with the last link.
+// Leading slashes are removed from entry names, and ErrInsecurePath is
+// returned for names that would escape dir, or that lead through a symlink
+// already in dir. Symlinks pointing outside dir are handled according to
+// opts.Symlinks. A nil opts uses the defaults. Every file is opened through
+// an os.Root for dir, so nothing is written outside it even by way of
+// symlinks created concurrently.
+//
+// Unless opts.NoModTime is set, the access and modification times of files
+// and directories are set to the entry's ModTime, once their contents have
+// been written. Archives only store whole seconds, so the restored times
+// have no sub-second part. Symlinks and entries without a ModTime, as read
+// with Reader.UnsetZeroModTime, keep the time they were created.
+func Extract(r *Reader, dir string, opts *ExtractOptions) error {
+	return ExtractContext(context.Background(), r, dir, opts)
+}
+
+// ExtractContext is like Extract, but stops with the context's error if ctx
+// is done before the next entry is read. Files already being written by
+// other workers are finished first.
+func ExtractContext(ctx context.Context, r *Reader, dir string, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	var pool *extractPool
+	if opts.Parallelism > 1 {
+		pool = newExtractPool(opts.Parallelism)
+	}
+
+	links := new(extractLinks)
+	dirs, err := extractEntries(ctx, r, root, opts, pool, links)
+	if pool != nil {
+		werr := pool.wait()
+		if err == nil {
+			err = werr
+		}
+	}
+	if err == nil {
+		err = links.create(root)
+	}
+	if err != nil {
+		return err
+	}
+
+	// directories are created writable so they can be populated, then
+	// given their real permissions and times deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err = root.Chmod(dirs[i].path, dirs[i].mode)
+		if err == nil && !opts.NoModTime {
+			err = restoreModTime(root, dirs[i].path, dirs[i].modTime)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type extractDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func extractEntries(ctx context.Context, r *Reader, root *os.Root, opts *ExtractOptions, pool *extractPool, links *extractLinks) ([]extractDir, error) {
+	var dirs []extractDir
+	for {
+		if err := ctx.Err(); err != nil {
+			return dirs, err
+		}
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return dirs, nil
+		}
+		if err != nil {
+			return dirs, err
+		}
+		if pool != nil {
+			if err = pool.Err(); err != nil {
+				return dirs, err
+			}
+		}
+
+		target, err := extractPath(root, hdr.Name)
+		if err != nil {
+			return dirs, err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch {
+		case mode.IsDir():
+			err = root.MkdirAll(target, 0700)
+			dirs = append(dirs, extractDir{path: target, mode: mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky), modTime: hdr.ModTime})
+		case mode.IsRegular():
+			modTime := hdr.ModTime
+			if opts.NoModTime {
+				modTime = time.Time{}
+			}
+			if hdr.NLink > 1 && hdr.Inode != 0 && links.add(hdr, target, mode, modTime) {
+				break
+			}
+			if pool == nil {
+				err = extractFile(root, target, r, mode, modTime)
+				break
+			}
+			var data []byte
+			data, err = ioutil.ReadAll(r)
+			if err == nil {
+				pool.submit(func() error {
+					return extractFile(root, target, bytes.NewReader(data), mode, modTime)
+				})
+			}
+		case mode&os.ModeSymlink != 0:
+			var link []byte
+			var linkTarget string
+			link, err = ioutil.ReadAll(r)
+			if err == nil {
+				linkTarget, err = symlinkTarget(hdr.Name, string(link), opts.Symlinks)
+			}
+			if err == nil {
+				err = root.MkdirAll(filepath.Dir(target), 0755)
+			}
+			if err == nil {
+				err = removeSymlink(root, target)
+			}
+			if err == nil {
+				err = root.Symlink(linkTarget, target)
+			}
+		}
+		if err != nil {
+			return dirs, err
+		}
+	}
+}
+
+// VerifyExtraction reads the archive from r and checks each directory,
+// regular file and symlink against its counterpart in fsys, such as an
+// os.DirFS of the directory it was extracted to. Entry names are mapped as
+// by Extract. The type, permissions and size of each must match, along with
+// the content of regular files and the target of symlinks; other entries,
+// which Extract skips, are ignored, as is the content of hard links stored
+// without data. fsys must implement fs.ReadLinkFS for symlinks to be
+// checked.
+//
+// Each mismatch found is described in the returned list, in archive order.
+// An error is returned if the archive can't be read, or if fsys fails for
+// any reason other than a missing file.
+func VerifyExtraction(r io.Reader, fsys fs.FS) ([]string, error) {
+	cr := NewReader(r)
+	var diffs []string
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return diffs, nil
+		}
+		if err != nil {
+			return diffs, err
+		}
+		name := strings.TrimLeft(path.Clean(hdr.Name), "/")
+		if name == "" || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		mode := hdr.FileInfo().Mode()
+		if !mode.IsDir() && !mode.IsRegular() && mode&os.ModeSymlink == 0 {
+			continue
+		}
+
+		fi, err := fs.Lstat(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			diffs = append(diffs, name+": missing")
+			continue
+		}
+		if err != nil {
+			return diffs, err
+		}
+		if fi.Mode().Type() != mode.Type() {
+			diffs = append(diffs, fmt.Sprintf("%s: type %v != %v", name, mode.Type(), fi.Mode().Type()))
+			continue
+		}
+
+		const permBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+		switch {
+		case mode.IsDir():
+			if fi.Mode()&permBits != mode&permBits {
+				diffs = append(diffs, fmt.Sprintf("%s: mode %v != %v", name, mode&permBits, fi.Mode()&permBits))
+			}
+		case mode.IsRegular():
+			if fi.Mode()&permBits != mode&permBits {
+				diffs = append(diffs, fmt.Sprintf("%s: mode %v != %v", name, mode&permBits, fi.Mode()&permBits))
+			}
+			if hdr.Size == 0 && hdr.NLink > 1 && hdr.Inode != 0 {
+				// a hard link whose data comes with another link
+				continue
+			}
+			if fi.Size() != hdr.Size {
+				diffs = append(diffs, fmt.Sprintf("%s: size %d != %d", name, hdr.Size, fi.Size()))
+				continue
+			}
+			same, err := sameContent(cr, fsys, name)
+			if err != nil {
+				return diffs, err
+			}
+			if !same {
+				diffs = append(diffs, name+": content differs")
+			}
+		default:
+			link, err := ioutil.ReadAll(cr)
+			if err != nil {
+				return diffs, err
+			}
+			target, err := fs.ReadLink(fsys, name)
+			if err != nil {
+				return diffs, err
+			}
+			if target != string(link) {
+				diffs = append(diffs, fmt.Sprintf("%s: target %s != %s", name, link, target))
+			}
+		}
+	}
+}
+
+// sameContent reports whether the data read from r matches the file name
+// in fsys
+func sameContent(r io.Reader, fsys fs.FS, name string) (bool, error) {
+	ha := sha256.New()
+	if _, err := io.Copy(ha, r); err != nil {
+		return false, err
+	}
+	fd, err := fsys.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer fd.Close()
+	hb := sha256.New()
+	if _, err = io.Copy(hb, fd); err != nil {
+		return false, err
+	}
+	return bytes.Equal(ha.Sum(nil), hb.Sum(nil)), nil
+}
+
+// extractPath returns the location within root for an entry name. The
+// directories leading to it must not be symlinks, so that the entry lands
+// at its name and the targets of symlink entries are checked against the
+// directory they are really created in.
+func extractPath(root *os.Root, name string) (string, error) {
+	clean := strings.TrimLeft(path.Clean(name), "/")
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%w: %s", ErrInsecurePath, name)
+	}
+	if clean == "" {
+		return ".", nil
+	}
+	for i := range clean {
+		if clean[i] != '/' {
+			continue
+		}
+		fi, err := root.Lstat(filepath.FromSlash(clean[:i]))
+		if err != nil {
+			// anything deeper doesn't exist yet either
+			break
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %s leads through symlink %s", ErrInsecurePath, name, clean[:i])
+		}
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+// removeSymlink removes name from root if it is a symlink, so that it is
+// replaced rather than followed
+func removeSymlink(root *os.Root, name string) error {
+	fi, err := root.Lstat(name)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+	return root.Remove(name)
+}
+
+// climbsWithin reports whether the relative symlink target, followed from
+// dir, stays within the root. Any ".." must come before the other elements
+// of target: after one, ".." would climb out of wherever a symlink in the
+// path leads, which the text of target alone can't tell, so "a/b/.." is
+// rejected even though it cleans to "a". Leading ".." only climb through
+// the real directories above the symlink, as extracted paths never lead
+// through symlinks.
+func climbsWithin(dir, target string) bool {
+	depth := 0
+	if dir != "." {
+		depth = strings.Count(dir, "/") + 1
+	}
+	up, down := 0, false
+	for _, elem := range strings.Split(target, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if down {
+				return false
+			}
+			up++
+		default:
+			down = true
+		}
+	}
+	return up <= depth
+}
+
+// symlinkTarget applies policy to the target of the symlink entry name
+func symlinkTarget(name, target string, policy SymlinkPolicy) (string, error) {
+	if policy == SymlinkAllow {
+		return target, nil
+	}
+	dir := path.Dir(strings.TrimLeft(path.Clean(name), "/"))
+	if !path.IsAbs(target) && climbsWithin(dir, target) {
+		return target, nil
+	}
+	if policy != SymlinkRewrite {
+		return "", fmt.Errorf("%w: %s -> %s", ErrInsecureSymlink, name, target)
+	}
+
+	// resolve against the root, where ".." goes nowhere, then climb back
+	// up from the symlink's directory
+	rooted := target
+	if !path.IsAbs(target) {
+		rooted = "/" + dir + "/" + target
+	}
+	rel := strings.TrimPrefix(path.Clean(rooted), "/")
+	up := ""
+	if dir != "." {
+		up = strings.Repeat("../", strings.Count(dir, "/")+1)
+	}
+	if rel == "" {
+		if up == "" {
+			return ".", nil
+		}
+		return strings.TrimSuffix(up, "/"), nil
+	}
+	return up + rel, nil
+}
+
+// extractFile writes the data from r to target within root, then sets its
+// times to modTime unless it is zero
+func extractFile(root *os.Root, target string, r io.Reader, mode os.FileMode, modTime time.Time) error {
+	err := root.MkdirAll(filepath.Dir(target), 0755)
+	if err == nil {
+		err = removeSymlink(root, target)
+	}
+	if err != nil {
+		return err
+	}
+	fd, err := root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, r)
+	if err == nil {
+		err = fd.Chmod(mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+	}
+	cerr := fd.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// after closing, so no buffered write can update the times again
+		err = restoreModTime(root, target, modTime)
+	}
+	return err
+}
+
+// restoreModTime sets the access and modification times of the file at
+// name within root to modTime, unless it is zero
+func restoreModTime(root *os.Root, name string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+	return root.Chtimes(name, modTime, modTime)
+}
+
+// extractLinks collects the hard links in an archive, so they can be
+// created once the file carrying their data has been written
+type extractLinks struct {
+	groups []*extractLink
+	byKey  map[linkKey]*extractLink
+}
+
+// extractLink is a file with several links
+type extractLink struct {
+	source  string
+	names   []string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// add records the hard link at target, and reports whether it is left for
+// create. The first link with data is extracted as usual and becomes the
+// source of the others.
+func (l *extractLinks) add(hdr *Header, target string, mode os.FileMode, modTime time.Time) bool {
+	key := linkKey{hdr.DevMajor, hdr.DevMinor, hdr.Inode}
+	g := l.byKey[key]
+	if g == nil {
+		if l.byKey == nil {
+			l.byKey = make(map[linkKey]*extractLink)
+		}
+		g = &extractLink{mode: mode, modTime: modTime}
+		l.byKey[key] = g
+		l.groups = append(l.groups, g)
+	}
+	if g.source == "" && hdr.Size > 0 {
+		g.source = target
+		return false
+	}
+	if target != g.source {
+		g.names = append(g.names, target)
+	}
+	return true
+}
+
+// create links the names of each file to its source. Files whose links
+// all came without data are created empty at their first name.
+func (l *extractLinks) create(root *os.Root) error {
+	for _, g := range l.groups {
+		source, names := g.source, g.names
+		if source == "" {
+			source, names = names[0], names[1:]
+			err := extractFile(root, source, bytes.NewReader(nil), g.mode, g.modTime)
+			if err != nil {
+				return err
+			}
+		}
+		for _, name := range names {
+			if name == source {
+				continue
+			}
+			err := root.MkdirAll(filepath.Dir(name), 0755)
+			if err == nil {
+				err = removeFile(root, name)
+			}
+			if err == nil {
+				err = root.Link(source, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeFile removes name from root unless it is missing or a directory,
+// so it can be replaced by a link
+func removeFile(root *os.Root, name string) error {
+	fi, err := root.Lstat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	return root.Remove(name)
+}
+
+// extractPool runs file writes on a fixed number of workers
+type extractPool struct {
+	jobs chan func() error
+	wg   sync.WaitGroup
+
+	mx  sync.Mutex
+	err error
+}
+
+func newExtractPool(n int) *extractPool {
+	p := &extractPool{jobs: make(chan func() error)}
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *extractPool) work() {
+	defer p.wg.Done()
+	for job := range p.jobs {
+		err := job()
+		if err != nil {
+			p.mx.Lock()
+			if p.err == nil {
+				p.err = err
+			}
+			p.mx.Unlock()
+		}
+	}
+}
+
+// submit blocks until a worker is available to run job
+func (p *extractPool) submit(job func() error) {
+	p.jobs <- job
+}
+
+// Err returns the first error returned by a job
+func (p *extractPool) Err() error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.err
+}
+
+// wait stops accepting jobs and waits for those running to finish
+func (p *extractPool) wait() error {
+	close(p.jobs)
+	p.wg.Wait()
+	return p.Err()
+}
@@ -0,0 +1,397 @@
+package cpio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// extractTestArchive builds an archive with a directory tree, many small
+// files and a symlink
+func extractTestArchive(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	write := func(hdr *Header, body string) {
+		hdr.Encoding = EncodingTypeASCIISVR4
+		hdr.NLink = 1
+		hdr.ModTime = testModTime
+		hdr.Size = int64(len(body))
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	write(&Header{Name: "dir/", Mode: 040555}, "")
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("dir/file%d.txt", i)
+		write(&Header{Name: name, Mode: 0100640}, name)
+	}
+	write(&Header{Name: "/abs/file.txt", Mode: 0100644}, "absolute")
+	write(&Header{Name: "link", Mode: 0120777}, "dir/file1.txt")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	data := extractTestArchive(t)
+	for _, parallelism := range []int{0, 4} {
+		t.Run(fmt.Sprint("Parallelism", parallelism), func(t *testing.T) {
+			dir := t.TempDir()
+			defer os.Chmod(filepath.Join(dir, "dir"), 0755)
+
+			err := Extract(NewReader(bytes.NewReader(data)), dir, &ExtractOptions{Parallelism: parallelism})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 20; i++ {
+				name := fmt.Sprintf("dir/file%d.txt", i)
+				body, err := ioutil.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(body) != name {
+					t.Errorf("expected data to be '%s' but got '%s'", name, body)
+				}
+			}
+			if body, _ := ioutil.ReadFile(filepath.Join(dir, "abs/file.txt")); string(body) != "absolute" {
+				t.Errorf("expected data to be '%s' but got '%s'", "absolute", body)
+			}
+			if link, _ := os.Readlink(filepath.Join(dir, "link")); link != "dir/file1.txt" {
+				t.Errorf("expected link target to be '%s' but got '%s'", "dir/file1.txt", link)
+			}
+
+			fi, err := os.Stat(filepath.Join(dir, "dir/file3.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0640 {
+				t.Errorf("expected file mode to be %v but got %v", os.FileMode(0640), fi.Mode().Perm())
+			}
+			fi, err = os.Stat(filepath.Join(dir, "dir"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0555 {
+				t.Errorf("expected directory mode to be %v but got %v", os.FileMode(0555), fi.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestExtractInsecurePath(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a/../../escape.txt", "nope")
+	err := Extract(NewReader(bytes.NewReader(data)), t.TempDir(), nil)
+	if !errors.Is(err, ErrInsecurePath) {
+		t.Error("expected ErrInsecurePath but got:", err)
+	}
+}
+
+func TestExtractSymlinkPolicy(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	hdr := NewSymlinkHeader("usr/bin/sh", "/bin/sh")
+	hdr.ModTime = testModTime
+	w.AddReader(hdr, bytes.NewReader([]byte("/bin/sh")))
+	w.Close()
+	data := buf.Bytes()
+
+	err := Extract(NewReader(bytes.NewReader(data)), t.TempDir(), nil)
+	if !errors.Is(err, ErrInsecureSymlink) {
+		t.Error("expected ErrInsecureSymlink but got:", err)
+	}
+
+	dir := t.TempDir()
+	err = Extract(NewReader(bytes.NewReader(data)), dir, &ExtractOptions{Symlinks: SymlinkRewrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := os.Readlink(filepath.Join(dir, "usr", "bin", "sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link != "../../bin/sh" {
+		t.Errorf("expected rewritten target ../../bin/sh but got %s", link)
+	}
+
+	tests := []struct {
+		name, target, rewritten string
+		safe                    bool
+	}{
+		{"a/b", "../c", "../c", true},
+		{"a/b", "../../c", "../c", false},
+		{"a", "/", ".", false},
+		{"a/b/c", "/", "../..", false},
+		{"/a/b", "/etc/passwd", "../etc/passwd", false},
+		{"a", "x/../../../y", "y", false},
+		{"c", "a/b/..", "a", false},
+		{"a/b", "./../c", "./../c", true},
+	}
+	for _, test := range tests {
+		target, err := symlinkTarget(test.name, test.target, SymlinkReject)
+		if test.safe && (err != nil || target != test.target) {
+			t.Errorf("%s -> %s: expected it to be allowed but got %q, %v", test.name, test.target, target, err)
+		}
+		if !test.safe && !errors.Is(err, ErrInsecureSymlink) {
+			t.Errorf("%s -> %s: expected ErrInsecureSymlink but got %v", test.name, test.target, err)
+		}
+		target, _ = symlinkTarget(test.name, test.target, SymlinkRewrite)
+		if target != test.rewritten {
+			t.Errorf("%s -> %s: expected rewrite to %s but got %s", test.name, test.target, test.rewritten, target)
+		}
+	}
+}
+
+func TestExtractContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dir := t.TempDir()
+	err := ExtractContext(ctx, NewReader(bytes.NewReader(extractTestArchive(t))), dir, nil)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled but got:", err)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected nothing to be extracted but got %d entries", len(entries))
+	}
+}
+
+func TestExtractModTime(t *testing.T) {
+	data := extractTestArchive(t)
+	for _, parallelism := range []int{0, 4} {
+		t.Run(fmt.Sprint("Parallelism", parallelism), func(t *testing.T) {
+			dir := t.TempDir()
+			defer os.Chmod(filepath.Join(dir, "dir"), 0755)
+
+			err := Extract(NewReader(bytes.NewReader(data)), dir, &ExtractOptions{Parallelism: parallelism})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"dir", "dir/file3.txt", "abs/file.txt"} {
+				fi, err := os.Stat(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !fi.ModTime().Equal(testModTime) {
+					t.Errorf("expected %s ModTime to be %v but got %v", name, testModTime, fi.ModTime())
+				}
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	defer os.Chmod(filepath.Join(dir, "dir"), 0755)
+	err := Extract(NewReader(bytes.NewReader(data)), dir, &ExtractOptions{NoModTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, "dir/file3.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ModTime().Equal(testModTime) {
+		t.Error("expected ModTime not to be restored with NoModTime")
+	}
+}
+
+func TestExtractHardLinks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	add := func(name string, inode int, body string) {
+		hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: 0100644, Inode: inode, NLink: 3, Size: int64(len(body)), ModTime: testModTime}
+		if err := w.AddReader(hdr, strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// as GNU cpio writes newc, with the data on the last link only
+	add("a", 5, "")
+	add("dir/b", 5, "")
+	add("c", 5, "linked")
+	// with the data on every link, as in odc
+	add("d", 6, "again")
+	add("e", 6, "again")
+	add("f", 6, "again")
+	// an empty file
+	add("g", 7, "")
+	add("h", 7, "")
+	w.Close()
+	data := buf.Bytes()
+
+	for _, parallelism := range []int{0, 4} {
+		t.Run(fmt.Sprint("Parallelism", parallelism), func(t *testing.T) {
+			dir := t.TempDir()
+			err := Extract(NewReader(bytes.NewReader(data)), dir, &ExtractOptions{Parallelism: parallelism})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, group := range [][]string{{"a", "dir/b", "c"}, {"d", "e", "f"}, {"g", "h"}} {
+				first, err := os.Stat(filepath.Join(dir, group[0]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, name := range group {
+					fi, err := os.Stat(filepath.Join(dir, name))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !os.SameFile(first, fi) {
+						t.Errorf("expected %s to be a link of %s", name, group[0])
+					}
+				}
+			}
+			if body, _ := ioutil.ReadFile(filepath.Join(dir, "a")); string(body) != "linked" {
+				t.Errorf("expected a to contain %q but got %q", "linked", body)
+			}
+
+			diffs, err := VerifyExtraction(bytes.NewReader(data), os.DirFS(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(diffs) != 0 {
+				t.Errorf("expected no differences but got %q", diffs)
+			}
+		})
+	}
+}
+
+func TestExtractInodeZero(t *testing.T) {
+	// inode 0 marks synthetic entries, which are never links of each
+	// other, even as placeholders with an NLink above 1 and no data
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		body := name
+		if name == "d" {
+			body = ""
+		}
+		w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: 0100644, NLink: 3, Size: int64(len(body)), ModTime: testModTime}, strings.NewReader(body))
+	}
+	// a real link group, so inode 0 isn't excluded only for lack of one
+	w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "e", Mode: 0100644, Inode: 1, NLink: 2, ModTime: testModTime}, strings.NewReader(""))
+	w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "f", Mode: 0100644, Inode: 1, NLink: 2, Size: 1, ModTime: testModTime}, strings.NewReader("f"))
+	w.Close()
+
+	dir := t.TempDir()
+	if err := Extract(NewReader(buf), dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	var infos []os.FileInfo
+	for _, name := range []string{"a", "b", "c", "d"} {
+		body, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := name
+		if name == "d" {
+			expected = ""
+		}
+		if string(body) != expected {
+			t.Errorf("expected %s to contain %q but got %q", name, expected, body)
+		}
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, fi)
+	}
+	for i := range infos {
+		for j := i + 1; j < len(infos); j++ {
+			if os.SameFile(infos[i], infos[j]) {
+				t.Errorf("expected inode 0 entries %s and %s to be separate files", infos[i].Name(), infos[j].Name())
+			}
+		}
+	}
+
+	e, err := os.Stat(filepath.Join(dir, "e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Stat(filepath.Join(dir, "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(e, f) {
+		t.Error("expected e to be a link of f")
+	}
+}
+
+func TestVerifyExtraction(t *testing.T) {
+	data := extractTestArchive(t)
+	dir := t.TempDir()
+	defer os.Chmod(filepath.Join(dir, "dir"), 0755)
+
+	err := Extract(NewReader(bytes.NewReader(data)), dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := VerifyExtraction(bytes.NewReader(data), os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("expected no differences but got %q", diffs)
+	}
+
+	os.Chmod(filepath.Join(dir, "dir"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "dir/file2.txt"), []byte("dir/FILE2.txt"), 0640)
+	os.Remove(filepath.Join(dir, "dir/file3.txt"))
+	os.Remove(filepath.Join(dir, "link"))
+	os.Symlink("elsewhere", filepath.Join(dir, "link"))
+
+	diffs, err = VerifyExtraction(bytes.NewReader(data), os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"dir: mode -r-xr-xr-x != -rwxr-xr-x",
+		"dir/file2.txt: content differs",
+		"dir/file3.txt: missing",
+		"link: target dir/file1.txt != elsewhere",
+	}
+	if strings.Join(diffs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected differences %q but got %q", expected, diffs)
+	}
+}
+
+func TestExtractSymlinkChain(t *testing.T) {
+	// each symlink is safe on its own, but a/b is really b -> .. once a
+	// points at the destination itself
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AddReader(NewSymlinkHeader("a", "."), strings.NewReader("."))
+	w.AddReader(NewSymlinkHeader("a/b", ".."), strings.NewReader(".."))
+	w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "b/evil", Mode: 0100644, NLink: 1, Size: 4}, strings.NewReader("evil"))
+	w.Close()
+
+	parent := t.TempDir()
+	err := Extract(NewReader(buf), filepath.Join(parent, "dest"), nil)
+	if !errors.Is(err, ErrInsecurePath) {
+		t.Errorf("expected ErrInsecurePath but got: %v", err)
+	}
+	if _, err = os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written outside the destination but got: %v", err)
+	}
+}
+
+func TestExtractSymlinkDotDot(t *testing.T) {
+	// a/b is the destination itself, so a/b/.. is its parent, though the
+	// target cleans to just a
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AddReader(NewSymlinkHeader("a/b", ".."), strings.NewReader(".."))
+	w.AddReader(NewSymlinkHeader("c", "a/b/.."), strings.NewReader("a/b/.."))
+	w.Close()
+
+	err := Extract(NewReader(buf), t.TempDir(), nil)
+	if !errors.Is(err, ErrInsecureSymlink) {
+		t.Errorf("expected ErrInsecureSymlink but got: %v", err)
+	}
+}
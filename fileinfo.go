@@ -53,19 +53,12 @@ func FileInfoHeader(fi os.FileInfo) (*Header, error) {
 		return nil, fmt.Errorf("github.com/mastercactapus/gocpio: unknown file mode %v", fm)
 	}
 
-	if fm&os.ModeSetuid != 0 {
-		h.Mode |= modeSUID
-	}
-	if fm&os.ModeSetgid != 0 {
-		h.Mode |= modeSGID
-	}
-	if fm&os.ModeSticky != 0 {
-		h.Mode |= modeSticky
-	}
+	h.Mode |= specialBits(fm)
 
 	if sys, ok := fi.Sys().(*Header); ok {
 		// if this FileInfo came from Header, use the original to populate
-		// remaining fields
+		// remaining fields, keeping any mode bits os.FileMode can't represent
+		h.Mode = sys.Mode
 		h.Checksum = sys.Checksum
 		h.DevMajor = sys.DevMajor
 		h.DevMinor = sys.DevMinor
@@ -81,6 +74,81 @@ func FileInfoHeader(fi os.FileInfo) (*Header, error) {
 	return h, nil
 }
 
+// specialBits returns the setuid, setgid and sticky mode bits of fm
+func specialBits(fm os.FileMode) int64 {
+	var mode int64
+	if fm&os.ModeSetuid != 0 {
+		mode |= modeSUID
+	}
+	if fm&os.ModeSetgid != 0 {
+		mode |= modeSGID
+	}
+	if fm&os.ModeSticky != 0 {
+		mode |= modeSticky
+	}
+	return mode
+}
+
+// newHeader creates a Header of the given file type, keeping the
+// permission, setuid, setgid and sticky bits of mode
+func newHeader(name string, fileType int64, mode os.FileMode) *Header {
+	return &Header{
+		Name:  name,
+		Mode:  fileType | int64(mode.Perm()) | specialBits(mode),
+		NLink: 1,
+	}
+}
+
+// NewFileHeader creates a Header for a regular file of size bytes.
+// Like the other constructors, only the permission, setuid, setgid and
+// sticky bits of mode are used, and ModTime is left for the caller to set.
+func NewFileHeader(name string, size int64, mode os.FileMode) *Header {
+	h := newHeader(name, modeRegular, mode)
+	h.Size = size
+	return h
+}
+
+// NewEmptyFileHeader creates a Header for a regular file with no data, such
+// as a lock file or marker. It is typed as a regular file so that it is not
+// mistaken for a directory or a hard link placeholder.
+func NewEmptyFileHeader(name string, mode os.FileMode) *Header {
+	return NewFileHeader(name, 0, mode)
+}
+
+// NewDirHeader creates a Header for a directory.
+func NewDirHeader(name string, mode os.FileMode) *Header {
+	return newHeader(name, modeDirectory, mode)
+}
+
+// NewSymlinkHeader creates a Header for a symlink to target. The target must
+// be written as the entry's data.
+func NewSymlinkHeader(name, target string) *Header {
+	h := newHeader(name, modeSymlink, 0777)
+	h.Size = int64(len(target))
+	return h
+}
+
+// NewCharDeviceHeader creates a Header for a character device.
+func NewCharDeviceHeader(name string, major, minor int, mode os.FileMode) *Header {
+	h := newHeader(name, modeCharDev, mode)
+	h.RDevMajor = major
+	h.RDevMinor = minor
+	return h
+}
+
+// NewBlockDeviceHeader creates a Header for a block device.
+func NewBlockDeviceHeader(name string, major, minor int, mode os.FileMode) *Header {
+	h := newHeader(name, modeBlkDev, mode)
+	h.RDevMajor = major
+	h.RDevMinor = minor
+	return h
+}
+
+// NewFIFOHeader creates a Header for a named pipe.
+func NewFIFOHeader(name string, mode os.FileMode) *Header {
+	return newHeader(name, modeFIFO, mode)
+}
+
 type headerFileInfo struct {
 	h *Header
 }
@@ -95,12 +163,13 @@ func (fi headerFileInfo) ModTime() time.Time { return fi.h.ModTime }
 func (fi headerFileInfo) IsDir() bool        { return fi.Mode().IsDir() }
 func (fi headerFileInfo) Sys() interface{}   { return fi.h }
 
-// Name returns the base name of the file
+// Name returns the base name of the file, ignoring trailing slashes
+// whatever the file type. An empty name is returned as is.
 func (fi headerFileInfo) Name() string {
-	if fi.IsDir() {
-		return path.Base(path.Clean(fi.h.Name))
+	if fi.h.Name == "" {
+		return ""
 	}
-	return path.Base(fi.h.Name)
+	return path.Base(path.Clean(fi.h.Name))
 }
 
 // Mode returns the permission and mode bits for the headerFileInfo
@@ -0,0 +1,83 @@
+package cpio
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestHeaderConstructors(t *testing.T) {
+	tests := []struct {
+		hdr  *Header
+		mode os.FileMode
+		size int64
+	}{
+		{NewFileHeader("file", 12, 0644|os.ModeSetuid), 0644 | os.ModeSetuid, 12},
+		{NewDirHeader("dir", 0755|os.ModeSticky), os.ModeDir | 0755 | os.ModeSticky, 0},
+		{NewSymlinkHeader("link", "target"), os.ModeSymlink | 0777, 6},
+		{NewCharDeviceHeader("tty", 4, 1, 0620), os.ModeDevice | os.ModeCharDevice | 0620, 0},
+		{NewBlockDeviceHeader("sda", 8, 0, 0660), os.ModeDevice | 0660, 0},
+		{NewFIFOHeader("fifo", 0600|os.ModeDir), os.ModeNamedPipe | 0600, 0},
+	}
+	for _, test := range tests {
+		if mode := test.hdr.FileInfo().Mode(); mode != test.mode {
+			t.Errorf("%s: expected mode to be %v but got %v", test.hdr.Name, test.mode, mode)
+		}
+		intEq(t, test.hdr.Name+" Size", int(test.size), int(test.hdr.Size))
+		intEq(t, test.hdr.Name+" NLink", 1, test.hdr.NLink)
+	}
+
+	hdr := NewCharDeviceHeader("tty", 4, 1, 0620)
+	intEq(t, "RDevMajor", 4, hdr.RDevMajor)
+	intEq(t, "RDevMinor", 1, hdr.RDevMinor)
+}
+
+func TestFileInfoName(t *testing.T) {
+	tests := []struct {
+		name string
+		mode int64
+		base string
+	}{
+		{"", 0100644, ""},
+		{"/", 040755, "/"},
+		{"a/b/", 040755, "b"},
+		{"a/b/", 0100644, "b"},
+		{"a//b", 0100644, "b"},
+		{"./a/../c", 0100644, "c"},
+		{"file", 0100644, "file"},
+	}
+	for _, test := range tests {
+		h := &Header{Name: test.name, Mode: test.mode}
+		if base := h.FileInfo().Name(); base != test.base {
+			t.Errorf("Name of '%s': expected '%s' but got '%s'", test.name, test.base, base)
+		}
+	}
+}
+
+func TestEmptyFileRoundTrip(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			src := NewEmptyFileHeader("run/lock", 0644)
+			src.Encoding = enc
+			src.ModTime = testModTime
+
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			if err := w.WriteHeaderOnly(src); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+
+			hdr, err := NewReader(buf).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			intEq(t, "Mode", 0100644, int(hdr.Mode))
+			intEq(t, "Size", 0, int(hdr.Size))
+			intEq(t, "NLink", 1, hdr.NLink)
+			if !hdr.FileInfo().Mode().IsRegular() {
+				t.Error("expected a regular file")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package cpio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// ErrFrameInProgress is returned by FramedWriter.Next if the previous
+// archive has not been closed.
+var ErrFrameInProgress = errors.New("cpio: previous framed archive not closed")
+
+// A FramedWriter writes a sequence of cpio archives to a single stream.
+// Each archive is prefixed with its length in bytes as a big-endian uint64,
+// so the receiver knows where one archive ends and the next begins.
+type FramedWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+	cur *Writer
+}
+
+// NewFramedWriter creates a new FramedWriter writing to w
+func NewFramedWriter(w io.Writer) *FramedWriter {
+	return &FramedWriter{w: w}
+}
+
+// Next begins a new archive and returns a Writer for it.
+//
+// The archive is buffered in memory until the returned Writer is closed,
+// at which point it is written to the underlying writer with its length prefix.
+// Only one archive may be in progress at a time. An archive that can't be
+// closed, such as after ErrWriteTooShort, may be abandoned with Abort or the
+// Writer's Cancel, and nothing is written for it.
+func (fw *FramedWriter) Next() (*Writer, error) {
+	if fw.cur != nil && !fw.cur.closed {
+		return nil, ErrFrameInProgress
+	}
+	fw.buf.Reset()
+	fw.cur = NewWriter(&fw.buf)
+	fw.cur.onClose = fw.writeFrame
+	return fw.cur, nil
+}
+
+// Abort abandons the archive in progress, if any, without writing it, so
+// that Next may begin another.
+func (fw *FramedWriter) Abort() {
+	if fw.cur != nil {
+		fw.cur.Cancel()
+		fw.cur = nil
+	}
+	fw.buf.Reset()
+}
+
+func (fw *FramedWriter) writeFrame() error {
+	fw.cur = nil
+	var prefix [8]byte
+	binary.BigEndian.PutUint64(prefix[:], uint64(fw.buf.Len()))
+	_, err := fw.w.Write(prefix[:])
+	if err != nil {
+		return err
+	}
+	_, err = fw.buf.WriteTo(fw.w)
+	return err
+}
+
+// A FramedReader reads a sequence of length-prefixed cpio archives as
+// written by a FramedWriter.
+type FramedReader struct {
+	r  io.Reader
+	lr *io.LimitedReader
+}
+
+// NewFramedReader creates a new FramedReader reading from r
+func NewFramedReader(r io.Reader) *FramedReader {
+	return &FramedReader{r: r}
+}
+
+// Next advances to the next archive in the stream, discarding any unread
+// data from the current one.
+//
+// io.EOF is returned at the end of the input.
+func (fr *FramedReader) Next() (*Reader, error) {
+	if fr.lr != nil {
+		_, err := io.Copy(ioutil.Discard, fr.lr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var prefix [8]byte
+	_, err := io.ReadFull(fr.r, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+	fr.lr = &io.LimitedReader{R: fr.r, N: int64(binary.BigEndian.Uint64(prefix[:]))}
+	return NewReader(fr.lr), nil
+}
@@ -0,0 +1,109 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFramed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	fw := NewFramedWriter(buf)
+
+	names := []string{"first.txt", "second.txt", "third.txt"}
+	for _, name := range names {
+		w, err := fw.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fw.Next(); err != ErrFrameInProgress {
+			t.Error("expected ErrFrameInProgress but got:", err)
+		}
+		err = w.WriteHeader(&Header{Name: name, Mode: 0100644, NLink: 1, Size: int64(len(name)), ModTime: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, name)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fr := NewFramedReader(buf)
+	for _, name := range names {
+		r, err := fr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected Name to be '%s' but got '%s'", name, hdr.Name)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != name {
+			t.Errorf("expected data to be '%s' but got '%s'", name, data)
+		}
+		// leave the trailer unread; Next must skip it
+	}
+	if _, err := fr.Next(); err != io.EOF {
+		t.Error("expected io.EOF after last archive but got:", err)
+	}
+}
+
+func TestFramedAbandoned(t *testing.T) {
+	buf := new(bytes.Buffer)
+	fw := NewFramedWriter(buf)
+	good := func(name string) {
+		w, err := fw.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteHeader(&Header{Name: name, Mode: 0100644, NLink: 1, Size: int64(len(name)), ModTime: testModTime})
+		io.WriteString(w, name)
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a short entry can't be closed, so the archive must be aborted
+	w, _ := fw.Next()
+	w.WriteHeader(&Header{Name: "short", Mode: 0100644, NLink: 1, Size: 10, ModTime: testModTime})
+	if err := w.Close(); err != ErrWriteTooShort {
+		t.Error("expected ErrWriteTooShort but got:", err)
+	}
+	if _, err := fw.Next(); err != ErrFrameInProgress {
+		t.Error("expected ErrFrameInProgress but got:", err)
+	}
+	fw.Abort()
+	good("first.txt")
+
+	w, _ = fw.Next()
+	w.WriteHeader(&Header{Name: "canceled", Mode: 0100644, NLink: 1, ModTime: testModTime})
+	w.Cancel()
+	good("second.txt")
+
+	fr := NewFramedReader(buf)
+	for _, name := range []string{"first.txt", "second.txt"} {
+		r, err := fr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected Name to be '%s' but got '%s'", name, hdr.Name)
+		}
+	}
+	if _, err := fr.Next(); err != io.EOF {
+		t.Error("expected io.EOF after last archive but got:", err)
+	}
+}
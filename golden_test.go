@@ -0,0 +1,145 @@
+package cpio
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden files in test-data")
+
+// goldenBlockSize is the size golden archives are padded to, matching the
+// 512 byte blocks written by GNU cpio, which created the original files.
+const goldenBlockSize = 512
+
+// goldenFiles describes each golden archive: a single hello.txt entry in
+// the given encoding and mode.
+var goldenFiles = []struct {
+	name string
+	enc  EncodingType
+	mode int64
+}{
+	{"ascii-susv2.cpio", EncodingTypeASCIISUSv2, 0100664},
+	{"ascii-svr4.cpio", EncodingTypeASCIISVR4, 0100664},
+	{"ascii-svr4-crc.cpio", EncodingTypeASCIISVR4CRC, 0100664},
+	{"binary.cpio", EncodingTypeBinaryLE, 0100664},
+	{"zero-mode.cpio", EncodingTypeASCIISVR4, 0664},
+}
+
+// generateGolden writes each golden archive into dir using the Writer.
+// Run `go test -run TestGolden -update` to regenerate test-data after an
+// intentional change to the encoding.
+func generateGolden(dir string) error {
+	for _, g := range goldenFiles {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		w.ComputeChecksum = true
+		w.BlockSize = goldenBlockSize
+		err := w.WriteHeader(&Header{
+			Encoding: g.enc,
+			DevMinor: 44,
+			Inode:    1337,
+			UID:      1000,
+			GID:      1000,
+			NLink:    1,
+			Mode:     g.mode,
+			Size:     6,
+			Name:     "hello.txt",
+			ModTime:  time.Unix(1337, 0),
+		})
+		if err != nil {
+			return err
+		}
+		io.WriteString(w, "world\n")
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, g.name), buf.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestGolden(t *testing.T) {
+	if *update {
+		err := generateGolden("test-data")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "cpio-golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = generateGolden(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, g := range goldenFiles {
+		expected, err := ioutil.ReadFile(filepath.Join("test-data", g.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ioutil.ReadFile(filepath.Join(dir, g.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, actual) {
+			t.Errorf("%s is out of date, run go test -run TestGolden -update", g.name)
+		}
+	}
+}
+
+// TestGoldenNewcMulti rewrites test-data/newc-multi.cpio, written by
+// `bsdcpio -o -H newc` from files of 0 to 5 bytes with names of every length
+// modulo 4, a directory and a symlink, and compares the result to check the
+// alignment between entries. bsdcpio writes lowercase hex digits, so case is
+// ignored.
+func TestGoldenNewcMulti(t *testing.T) {
+	golden, err := ioutil.ReadFile("test-data/newc-multi.cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	r := NewReader(bytes.NewReader(golden))
+	entries := 0
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries++
+		err = w.AddReader(hdr, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "entries", 8, entries)
+
+	out := buf.Bytes()
+	if len(out) > len(golden) || !bytes.EqualFold(out, golden[:len(out)]) {
+		t.Errorf("Bad Output:\nExpected: %q\nActual:   %q", golden, out)
+	}
+	if !isZero(golden[len(out):]) {
+		t.Error("expected only block padding after the trailer")
+	}
+}
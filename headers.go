@@ -2,32 +2,70 @@ package cpio
 
 //go:generate stringer -type EncodingType
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // EncodingType is the header encoding type
 type EncodingType int
 
 // Header encoding types
 const (
-	// EncodingTypeASCIISUSv2 is also known as "odc" or "old character" format
+	// EncodingTypeASCIISUSv2 is also known as "odc" or "old character" format.
+	// Names are limited to 262142 bytes.
 	EncodingTypeASCIISUSv2 EncodingType = iota
 
-	// EncodingTypeASCIISVR4 is also known as "newc" or "new character" format
+	// EncodingTypeASCIISVR4 is also known as "newc" or "new character" format.
+	// Names are limited to 4294967294 bytes, so it is the best choice for
+	// very long paths.
 	EncodingTypeASCIISVR4
 
-	// EncodingTypeASCIISVR4CRC is also known as "crc" format
+	// EncodingTypeASCIISVR4CRC is also known as "crc" format.
+	// Names are limited as for EncodingTypeASCIISVR4.
 	EncodingTypeASCIISVR4CRC
+
+	// EncodingTypeBinaryLE and EncodingTypeBinaryBE are the little and
+	// big-endian variants of the "bin" format. Names are limited to 65534 bytes.
 	EncodingTypeBinaryLE
 	EncodingTypeBinaryBE
 )
 
+// Fixed header sizes in bytes, including the magic number but not the name.
+//
+// Each header is followed by the NUL-terminated name and then the entry's
+// data. In newc and crc archives the header and name together are padded
+// with NULs to a multiple of 4 bytes, as is the data. In bin archives the
+// name and data are each padded to an even length. odc has no padding.
+const (
+	HeaderSizeODC    = 76
+	HeaderSizeNewc   = 110
+	HeaderSizeBinary = 26
+)
+
+// Magic numbers at the start of each header
+const (
+	MagicODC  = "070707"
+	MagicNewc = "070701"
+	MagicCRC  = "070702"
+
+	// MagicBinary is stored as a 16-bit integer in the archive's byte order
+	MagicBinary = 070707
+)
+
 // Header is a universal cpio header structure
 //
-// DevMinor and RDevMinor are only relevant for types:
-// - EncodingTypeASCIISVR4
-// - EncodingTypeASCIISVR4CRC
+// EncodingTypeASCIISVR4 and EncodingTypeASCIISVR4CRC store the major and
+// minor device numbers in separate fields. EncodingTypeASCIISUSv2 and the
+// binary encodings store DevMajor and DevMinor in a single field, as do
+// RDevMajor and RDevMinor, with the minor number in the low 8 bits.
 //
 // Furthermore, Checksum is only valid for: EncodingTypeASCIISVR4CRC
+//
+// Directory names may end in a single slash, as set by FileInfoHeader, to
+// mark them as directories for tools that go by name. The Writer collapses
+// repeated trailing slashes into one, or removes them with
+// Writer.TrimDirSlash, and CleanName returns the name without them.
 type Header struct {
 	Name      string       // name of header file entry
 	Mode      int64        // permission and mode bits
@@ -41,7 +79,7 @@ type Header struct {
 	RDevMinor int          // associated device number (minor) for special and character entries
 	ModTime   time.Time    // modified time
 	Size      int64        // length in bytes
-	Checksum  int          // checksum (if `Encoding` is `EncodingTypeASCIISVR4CRC`)
+	Checksum  uint32       // checksum (if `Encoding` is `EncodingTypeASCIISVR4CRC`)
 	Encoding  EncodingType // encoding type for the header
 }
 
@@ -57,3 +95,114 @@ type binaryHeader struct {
 	Namesize uint16
 	Filesize [2]uint16
 }
+
+// HasModTime reports whether the header has a modification time.
+//
+// It returns false if ModTime is the zero time.Time, as set by a Reader
+// with UnsetZeroModTime for entries whose mtime field is zero.
+func (h *Header) HasModTime() bool {
+	return !h.ModTime.IsZero()
+}
+
+// CleanName returns Name without any trailing slashes if the header is for
+// a directory, so names compare equal whether or not the archive marks
+// directories with a trailing slash. Other names are returned unchanged.
+func (h *Header) CleanName() string {
+	if h.Mode&^07777 != modeDirectory {
+		return h.Name
+	}
+	name := strings.TrimRight(h.Name, "/")
+	if name == "" && h.Name != "" {
+		return "/"
+	}
+	return name
+}
+
+// normalizeDirName returns Name with exactly one trailing slash if the
+// header is for a directory whose name has any. Other names are returned
+// unchanged, including directory names without a trailing slash, as
+// written by cpio itself.
+func (h *Header) normalizeDirName() string {
+	if h.Mode&^07777 != modeDirectory || !strings.HasSuffix(h.Name, "/") {
+		return h.Name
+	}
+	return strings.TrimRight(h.Name, "/") + "/"
+}
+
+// STDev returns the device number of the file system the entry came from,
+// combining DevMajor and DevMinor as Linux encodes st_dev, so that STDev and
+// Inode together identify a file even in archives spanning devices.
+//
+// For major numbers below 4096 and minor numbers below 256 this is
+// major<<8 | minor, the value stored in odc and binary headers, so the
+// result is the same whichever encoding the entry was read from.
+func (h *Header) STDev() uint64 {
+	major, minor := uint64(h.DevMajor), uint64(h.DevMinor)
+	return major&0xfffff000<<32 | major&0xfff<<8 | minor&0xffffff00<<12 | minor&0xff
+}
+
+// packDev combines a major and minor device number into a single field,
+// with the minor number in the low 8 bits.
+func packDev(major, minor int) int64 {
+	return int64(major)<<8 | int64(minor)
+}
+
+// unpackDev splits a device number combined by packDev.
+func unpackDev(dev int64) (major, minor int) {
+	return int(dev >> 8), int(dev & 0xff)
+}
+
+// Equal reports whether h and other have identical fields, comparing
+// ModTime as an instant regardless of location.
+func (h *Header) Equal(other *Header) bool {
+	return h.Name == other.Name &&
+		h.Mode == other.Mode &&
+		h.DevMajor == other.DevMajor &&
+		h.DevMinor == other.DevMinor &&
+		h.Inode == other.Inode &&
+		h.UID == other.UID &&
+		h.GID == other.GID &&
+		h.NLink == other.NLink &&
+		h.RDevMajor == other.RDevMajor &&
+		h.RDevMinor == other.RDevMinor &&
+		h.ModTime.Equal(other.ModTime) &&
+		h.Size == other.Size &&
+		h.Checksum == other.Checksum &&
+		h.Encoding == other.Encoding
+}
+
+// EqualContent reports whether h and other describe the same file, comparing
+// only the name, mode including file type, and size. Volatile fields such as
+// ModTime, Inode and ownership are ignored.
+func (h *Header) EqualContent(other *Header) bool {
+	return h.Name == other.Name &&
+		h.Mode == other.Mode &&
+		h.Size == other.Size
+}
+
+// headerSize returns the length of an encoded header and name, including
+// the padding following the name.
+func headerSize(enc EncodingType, nameSize int) int64 {
+	n := int64(nameSize)
+	switch enc {
+	case EncodingTypeBinaryLE, EncodingTypeBinaryBE:
+		return HeaderSizeBinary + n + n%2
+	case EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC:
+		n += HeaderSizeNewc
+		return n + (4-n%4)%4
+	default:
+		return HeaderSizeODC + n
+	}
+}
+
+// dataPad returns the length of the padding following size bytes of data.
+func dataPad(enc EncodingType, size int64) int64 {
+	switch enc {
+	case EncodingTypeBinaryLE, EncodingTypeBinaryBE:
+		return size % 2
+	case EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC:
+		return (4 - size%4) % 4
+	default:
+		return 0
+	}
+}
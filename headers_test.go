@@ -0,0 +1,124 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHeaderCleanName(t *testing.T) {
+	tests := []struct {
+		name  string
+		mode  int64
+		clean string
+	}{
+		{"dir", 040755, "dir"},
+		{"dir/", 040755, "dir"},
+		{"a/b//", 040755, "a/b"},
+		{"/", 040755, "/"},
+		{"file/", 0100644, "file/"},
+	}
+	for _, test := range tests {
+		h := &Header{Name: test.name, Mode: test.mode}
+		if clean := h.CleanName(); clean != test.clean {
+			t.Errorf("CleanName of '%s': expected '%s' but got '%s'", test.name, test.clean, clean)
+		}
+	}
+}
+
+func TestHeaderEqual(t *testing.T) {
+	a := &Header{Name: "file", Mode: 0100644, UID: 1000, Inode: 5, Size: 3, ModTime: time.Unix(1337, 0)}
+	b := *a
+	b.ModTime = b.ModTime.UTC()
+	if !a.Equal(&b) {
+		t.Error("expected headers differing only in ModTime location to be Equal")
+	}
+
+	b.Inode = 6
+	b.UID = 0
+	b.ModTime = time.Unix(0, 0)
+	if a.Equal(&b) {
+		t.Error("expected headers with different inodes not to be Equal")
+	}
+	if !a.EqualContent(&b) {
+		t.Error("expected headers differing only in volatile fields to have EqualContent")
+	}
+
+	b.Mode = 0100755
+	if a.EqualContent(&b) {
+		t.Error("expected headers with different modes not to have EqualContent")
+	}
+}
+
+func TestHeaderDirNames(t *testing.T) {
+	tests := []struct {
+		name, written, trimmed string
+	}{
+		{"foo", "foo", "foo"},
+		{"foo/", "foo/", "foo"},
+		{"foo//", "foo/", "foo"},
+		{"//", "/", "/"},
+	}
+	for _, test := range tests {
+		for _, trim := range []bool{false, true} {
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			w.TrimDirSlash = trim
+			if err := w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: test.name, Mode: 040755, NLink: 2, ModTime: testModTime}); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+
+			hdr, err := NewReader(buf).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := test.written
+			if trim {
+				expected = test.trimmed
+			}
+			if hdr.Name != expected {
+				t.Errorf("%q (trim %v): expected name to be %q but got %q", test.name, trim, expected, hdr.Name)
+			}
+			if hdr.CleanName() != test.trimmed {
+				t.Errorf("%q (trim %v): expected clean name to be %q but got %q", test.name, trim, test.trimmed, hdr.CleanName())
+			}
+		}
+	}
+}
+
+func TestHeaderSTDev(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		err := w.WriteHeaderOnly(&Header{Encoding: enc, Name: "file", Mode: 0100644, NLink: 1, DevMajor: 8, DevMinor: 3, ModTime: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		hdr, err := NewReader(buf).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.DevMajor != 8 || hdr.DevMinor != 3 {
+			t.Errorf("%v: expected dev to be 8, 3 but got %d, %d", enc, hdr.DevMajor, hdr.DevMinor)
+		}
+		if hdr.STDev() != 0x803 {
+			t.Errorf("%v: expected STDev to be 0x803 but got %#x", enc, hdr.STDev())
+		}
+	}
+
+	// large numbers as used by Linux for anonymous and NVMe devices
+	hdr := &Header{DevMajor: 259, DevMinor: 65536}
+	if hdr.STDev() != 0x10010300 {
+		t.Errorf("expected STDev to be 0x10010300 but got %#x", hdr.STDev())
+	}
+
+	w := NewWriter(new(bytes.Buffer))
+	err := w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISUSv2, Name: "file", Mode: 0100644, NLink: 1, DevMinor: 300, ModTime: testModTime})
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+}
@@ -0,0 +1,79 @@
+package cpio
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrIndexMismatch is returned by IndexedReader if the header found at an
+// indexed offset does not match the index.
+var ErrIndexMismatch = errors.New("cpio: archive does not match index")
+
+// IndexEntry records the location of an entry within an archive, as
+// collected by a Writer with BuildIndex set.
+type IndexEntry struct {
+	Name       string // name of the entry
+	Offset     int64  // offset of the header from the start of the archive
+	HeaderSize int64  // length of the header, including the name and its padding
+	Size       int64  // length of the entry's data
+	Pad        int64  // length of the padding following the data
+}
+
+// An IndexedReader provides random access to the entries of an archive
+// using an index recorded when it was written.
+type IndexedReader struct {
+	r     io.ReaderAt
+	index map[string]IndexEntry
+}
+
+// NewIndexedReader creates a new IndexedReader reading from r using index.
+// If a name appears more than once in index, the last entry is used.
+func NewIndexedReader(r io.ReaderAt, index []IndexEntry) *IndexedReader {
+	ir := &IndexedReader{r: r, index: make(map[string]IndexEntry, len(index))}
+	for _, e := range index {
+		ir.index[e.Name] = e
+	}
+	return ir
+}
+
+// Open returns a reader over the data of the named entry, along with its header.
+//
+// The header at the indexed offset is read and checked against the index,
+// returning ErrIndexMismatch if they disagree.
+func (ir *IndexedReader) Open(name string) (io.ReadSeeker, *Header, error) {
+	e, hdr, err := ir.lookup(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NewSectionReader(ir.r, e.Offset+e.HeaderSize, e.Size), hdr, nil
+}
+
+// ReaderAt returns an io.ReaderAt over the data of the named entry, along
+// with its length. Offsets are relative to the start of the entry's data
+// and reads are bounded to it, so disjoint ranges may be read concurrently.
+func (ir *IndexedReader) ReaderAt(name string) (io.ReaderAt, int64, error) {
+	e, _, err := ir.lookup(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return io.NewSectionReader(ir.r, e.Offset+e.HeaderSize, e.Size), e.Size, nil
+}
+
+func (ir *IndexedReader) lookup(name string) (IndexEntry, *Header, error) {
+	e, ok := ir.index[name]
+	if !ok {
+		return e, nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	hdr, err := NewReader(io.NewSectionReader(ir.r, e.Offset, e.HeaderSize)).Next()
+	switch {
+	case err == io.EOF, err == io.ErrUnexpectedEOF, errors.Is(err, ErrHeader), err == ErrMissingTrailer:
+		return e, nil, ErrIndexMismatch
+	case err != nil:
+		return e, nil, err
+	case hdr.Name != e.Name, hdr.Size != e.Size:
+		return e, nil, ErrIndexMismatch
+	}
+	return e, hdr, nil
+}
@@ -0,0 +1,130 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWriterIndex(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			w.BuildIndex = true
+			w.ComputeChecksum = true
+			bodies := map[string]string{"a": "first", "bb": "second!", "ccc": ""}
+			for _, name := range []string{"a", "bb", "ccc"} {
+				w.WriteHeader(&Header{Encoding: enc, Name: name, Mode: 0100644, NLink: 1, Size: int64(len(bodies[name])), ModTime: testModTime})
+				io.WriteString(w, bodies[name])
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			data := buf.Bytes()
+			index := w.Index()
+			intEq(t, "len(Index)", 3, len(index))
+			for i, e := range index {
+				if i > 0 {
+					prev := index[i-1]
+					intEq(t, "Offset", int(prev.Offset+prev.HeaderSize+prev.Size+prev.Pad), int(e.Offset))
+				}
+				hdr, err := NewReader(bytes.NewReader(data[e.Offset:])).Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Name != e.Name {
+					t.Errorf("expected Name to be '%s' but got '%s'", e.Name, hdr.Name)
+				}
+				body := string(data[e.Offset+e.HeaderSize : e.Offset+e.HeaderSize+e.Size])
+				if body != bodies[e.Name] {
+					t.Errorf("expected data to be '%s' but got '%s'", bodies[e.Name], body)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexedReader(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.BuildIndex = true
+	for _, name := range []string{"one", "two", "three"} {
+		w.WriteHeader(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: 0100644, NLink: 1, Size: int64(len(name)), ModTime: testModTime})
+		io.WriteString(w, name)
+	}
+	w.Close()
+	data := buf.Bytes()
+
+	ir := NewIndexedReader(bytes.NewReader(data), w.Index())
+	for _, name := range []string{"three", "one", "two"} {
+		rs, hdr, err := ir.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected Name to be '%s' but got '%s'", name, hdr.Name)
+		}
+		body, err := ioutil.ReadAll(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != name {
+			t.Errorf("expected data to be '%s' but got '%s'", name, body)
+		}
+	}
+
+	if _, _, err := ir.Open("missing"); !os.IsNotExist(err) {
+		t.Error("expected not-exist error but got:", err)
+	}
+
+	index := w.Index()
+	index[0].Name = "two"
+	index[1].Name = "one"
+	ir = NewIndexedReader(bytes.NewReader(data), index)
+	if _, _, err := ir.Open("one"); err != ErrIndexMismatch {
+		t.Error("expected ErrIndexMismatch but got:", err)
+	}
+
+	// offsets that land inside an entry or past the end of the archive
+	for _, off := range []int64{3, int64(len(data))} {
+		index = w.Index()
+		index[0].Name = "one"
+		index[0].Offset = off
+		ir = NewIndexedReader(bytes.NewReader(data), index)
+		if _, _, err := ir.Open("one"); err != ErrIndexMismatch {
+			t.Errorf("offset %d: expected ErrIndexMismatch but got: %v", off, err)
+		}
+	}
+}
+
+func TestIndexedReaderAt(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.BuildIndex = true
+	w.WriteHeader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "blob", Mode: 0100644, NLink: 1, Size: 10, ModTime: testModTime})
+	io.WriteString(w, "0123456789")
+	w.Close()
+
+	ra, size, err := NewIndexedReader(bytes.NewReader(buf.Bytes()), w.Index()).ReaderAt("blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "size", 10, int(size))
+
+	b := make([]byte, 4)
+	n, err := ra.ReadAt(b, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:n]) != "3456" {
+		t.Errorf("expected data to be '%s' but got '%s'", "3456", b[:n])
+	}
+	n, err = ra.ReadAt(b, 8)
+	if err != io.EOF || string(b[:n]) != "89" {
+		t.Errorf("expected '89' and io.EOF at the end of the entry but got '%s' and %v", b[:n], err)
+	}
+}
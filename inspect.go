@@ -0,0 +1,55 @@
+package cpio
+
+import "io"
+
+// ArchiveInfo summarizes an archive, as returned by Inspect.
+type ArchiveInfo struct {
+	// Encoding is the encoding of the first entry.
+	Encoding EncodingType
+
+	// Entries is the number of entries, not counting the trailer.
+	Entries int
+
+	// TotalSize is the sum of the entries' data sizes.
+	TotalSize int64
+
+	// HasTrailer reports whether the archive ended with a trailer. It is
+	// false if the input ended cleanly between entries instead.
+	HasTrailer bool
+
+	// Largest is the header of the entry with the most data, or nil if
+	// there are no entries.
+	Largest *Header
+}
+
+// Inspect reads the headers of the archive from r up to its trailer,
+// skipping over entry data, and returns a summary. Data is skipped by
+// seeking when r is an io.Seeker.
+//
+// An archive missing its trailer is not an error; HasTrailer is false
+// instead. Other errors, such as an invalid header, are returned.
+func Inspect(r io.Reader) (*ArchiveInfo, error) {
+	cr := NewReader(r)
+	info := new(ArchiveInfo)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			info.HasTrailer = true
+			return info, nil
+		}
+		if err == ErrMissingTrailer {
+			return info, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if info.Entries == 0 {
+			info.Encoding = hdr.Encoding
+		}
+		info.Entries++
+		info.TotalSize += hdr.Size
+		if info.Largest == nil || hdr.Size > info.Largest.Size {
+			info.Largest = hdr
+		}
+	}
+}
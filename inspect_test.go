@@ -0,0 +1,52 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISUSv2, "a", "hello", "b", "hello, world", "c", "")
+	info, err := Inspect(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoding != EncodingTypeASCIISUSv2 {
+		t.Errorf("expected Encoding to be %v but got %v", EncodingTypeASCIISUSv2, info.Encoding)
+	}
+	intEq(t, "Entries", 3, info.Entries)
+	intEq(t, "TotalSize", 17, int(info.TotalSize))
+	if !info.HasTrailer {
+		t.Error("expected HasTrailer to be true")
+	}
+	if info.Largest == nil || info.Largest.Name != "b" {
+		t.Errorf("expected Largest to be b but got %+v", info.Largest)
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "a", Mode: 0100644, NLink: 1, Size: 1, ModTime: testModTime}, strings.NewReader("x"))
+	w.Flush()
+	info, err = Inspect(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "Entries", 1, info.Entries)
+	if info.HasTrailer {
+		t.Error("expected HasTrailer to be false")
+	}
+
+	info, err = Inspect(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Entries != 0 || info.Largest != nil {
+		t.Errorf("expected no entries but got %+v", info)
+	}
+
+	if _, err = Inspect(strings.NewReader("junk")); !errors.Is(err, ErrHeader) {
+		t.Error("expected ErrHeader but got:", err)
+	}
+}
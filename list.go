@@ -0,0 +1,54 @@
+package cpio
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// List writes a line describing each remaining entry in the archive to w,
+// in the style of `cpio -tv`: permissions, link count, numeric owner and
+// group, size, modification time and name.
+//
+// Devices show their major and minor numbers in place of the size, and
+// symlinks show their target after the name. List stops at the trailer.
+func (cr *Reader) List(w io.Writer) error {
+	now := time.Now()
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := hdr.FileInfo().Mode()
+		size := fmt.Sprintf("%8d", hdr.Size)
+		if mode&os.ModeDevice != 0 {
+			size = fmt.Sprintf("%3d, %3d", hdr.RDevMajor, hdr.RDevMinor)
+		}
+
+		// like ls, show the year instead of the time for old or future files
+		modTime := hdr.ModTime.Format("Jan _2 15:04")
+		if age := now.Sub(hdr.ModTime); age < 0 || age > 6*30*24*time.Hour {
+			modTime = hdr.ModTime.Format("Jan _2  2006")
+		}
+
+		name := hdr.Name
+		if mode&os.ModeSymlink != 0 {
+			link, err := ioutil.ReadAll(cr)
+			if err != nil {
+				return err
+			}
+			name += " -> " + string(link)
+		}
+
+		_, err = fmt.Fprintf(w, "%s %3d %-8d %-8d %s %s %s\n", mode, hdr.NLink, hdr.UID, hdr.GID, size, modTime, name)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReaderList(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteHeader(&Header{Name: "hello.txt", Mode: 0100664, UID: 1000, GID: 100, NLink: 1, Size: 6, ModTime: testModTime})
+	io.WriteString(w, "world\n")
+	w.WriteHeader(&Header{Name: "link", Mode: 0120777, NLink: 1, Size: 9, ModTime: testModTime})
+	io.WriteString(w, "hello.txt")
+	w.WriteHeader(&Header{Name: "tty", Mode: 020620, NLink: 1, RDevMajor: 4, RDevMinor: 1, ModTime: testModTime, Encoding: EncodingTypeASCIISVR4})
+	w.Close()
+
+	out := new(bytes.Buffer)
+	err := NewReader(buf).List(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	date := testModTime.Format("Jan _2  2006")
+	expected := []string{
+		"-rw-rw-r--   1 1000     100             6 " + date + " hello.txt",
+		"Lrwxrwxrwx   1 0        0               9 " + date + " link -> hello.txt",
+		"Dcrw--w----   1 0        0          4,   1 " + date + " tty",
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines but got:\n%s", len(expected), out)
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Errorf("line %d:\nexpected: %s\nactual:   %s", i, expected[i], line)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package cpio
+
+import "io"
+
+// mergeEntry identifies an entry by its layer and position within it
+type mergeEntry struct {
+	layer, n int
+}
+
+// MergeSeekable writes the entries of layers to out, with entries in later
+// layers replacing those of the same name in earlier ones, as when applying
+// the layers of an image in order. Names are compared using
+// Header.CleanName.
+//
+// The layers are read twice: first to find the winning entry for each name,
+// then to copy the winners' headers and data, so no data is held in memory.
+// Winning entries are written in the order they appear, layer by layer.
+// out is not closed, so more entries may be written before its trailer.
+func MergeSeekable(out *Writer, layers ...io.ReaderAt) error {
+	winners := make(map[string]mergeEntry)
+	for i, layer := range layers {
+		err := mergeForEach(layer, func(n int, hdr *Header, r io.Reader) error {
+			winners[hdr.CleanName()] = mergeEntry{i, n}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, layer := range layers {
+		err := mergeForEach(layer, func(n int, hdr *Header, r io.Reader) error {
+			if winners[hdr.CleanName()] != (mergeEntry{i, n}) {
+				return nil
+			}
+			return out.AddReader(hdr, r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeForEach calls fn with each entry of layer and its position
+func mergeForEach(layer io.ReaderAt, fn func(n int, hdr *Header, r io.Reader) error) error {
+	cr := NewReaderAt(layer, 0)
+	for n := 0; ; n++ {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(n, hdr, cr)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cpio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMergeSeekable(t *testing.T) {
+	base := testArchive(t, EncodingTypeASCIISVR4, "etc/hostname", "base", "etc/motd", "hello", "bin/sh", "sh")
+	top := testArchive(t, EncodingTypeASCIISVR4, "etc/motd", "welcome", "etc/issue", "issue")
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	if err := MergeSeekable(w, bytes.NewReader(base), bytes.NewReader(top)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{ name, body string }{
+		{"etc/hostname", "base"},
+		{"bin/sh", "sh"},
+		{"etc/motd", "welcome"},
+		{"etc/issue", "issue"},
+	}
+	r := NewReader(buf)
+	for _, e := range expected {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != e.name || string(body) != e.body {
+			t.Errorf("expected %s with %q but got %s with %q", e.name, e.body, hdr.Name, body)
+		}
+	}
+	if hdr, err := r.Next(); err == nil {
+		t.Errorf("expected no more entries but got %s", hdr.Name)
+	}
+}
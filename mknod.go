@@ -0,0 +1,35 @@
+package cpio
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	// ErrNotSpecial is returned by Header.Mknod for headers that are not
+	// devices or FIFOs
+	ErrNotSpecial = errors.New("cpio: header is not a device or FIFO")
+
+	// ErrMknodUnsupported is returned by Header.Mknod on platforms where
+	// special files can't be created
+	ErrMknodUnsupported = errors.New("cpio: mknod is not supported on this platform")
+)
+
+// Mknod creates the special file described by h at path. Character and
+// block devices are created with the device number from RDevMajor and
+// RDevMinor, and FIFOs as named pipes, using the permission bits from Mode.
+//
+// Creating devices usually requires elevated privileges. Mknod is only
+// supported on Linux and macOS, returning ErrMknodUnsupported elsewhere.
+func (h *Header) Mknod(path string) error {
+	switch h.Mode &^ 07777 {
+	case modeCharDev, modeBlkDev, modeFIFO:
+	default:
+		return &os.PathError{Op: "mknod", Path: path, Err: ErrNotSpecial}
+	}
+	err := mknod(path, h)
+	if err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	return nil
+}
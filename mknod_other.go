@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package cpio
+
+func mknod(path string, h *Header) error {
+	return ErrMknodUnsupported
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package cpio
+
+import (
+	"runtime"
+	"syscall"
+)
+
+func mknod(path string, h *Header) error {
+	perm := uint32(h.Mode & 07777)
+	switch h.Mode &^ 07777 {
+	case modeCharDev:
+		return syscall.Mknod(path, syscall.S_IFCHR|perm, mkdev(h.RDevMajor, h.RDevMinor))
+	case modeBlkDev:
+		return syscall.Mknod(path, syscall.S_IFBLK|perm, mkdev(h.RDevMajor, h.RDevMinor))
+	default:
+		return syscall.Mkfifo(path, perm)
+	}
+}
+
+// mkdev encodes a device number as the platform's dev_t
+func mkdev(major, minor int) int {
+	if runtime.GOOS == "darwin" {
+		return major<<24 | minor&0xffffff
+	}
+
+	// same layout as glibc's makedev
+	maj, min := uint64(major), uint64(minor)
+	return int(maj&0xfff<<8 | maj&^0xfff<<32 | min&0xff | min&^0xff<<12)
+}
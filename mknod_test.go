@@ -0,0 +1,37 @@
+package cpio
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestHeaderMknod(t *testing.T) {
+	dir := t.TempDir()
+
+	reg := &Header{Name: "file", Mode: 0100644}
+	if err := reg.Mknod(filepath.Join(dir, "file")); !errors.Is(err, ErrNotSpecial) {
+		t.Error("expected ErrNotSpecial but got:", err)
+	}
+
+	fifo := &Header{Name: "fifo", Mode: 010640}
+	err := fifo.Mknod(filepath.Join(dir, "fifo"))
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		if !errors.Is(err, ErrMknodUnsupported) {
+			t.Error("expected ErrMknodUnsupported but got:", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(dir, "fifo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("expected a named pipe but got %v", fi.Mode())
+	}
+}
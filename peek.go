@@ -0,0 +1,69 @@
+package cpio
+
+import "io"
+
+// A PeekReader is an io.Reader whose upcoming bytes can be examined with
+// Peek before they are read, such as to detect an archive's format. Unlike
+// a bufio.Reader it only reads ahead as far as Peek asks, so no more is
+// taken from the underlying reader than is needed.
+type PeekReader struct {
+	r   io.Reader
+	buf []byte
+	err error
+}
+
+// NewPeekReader creates a new PeekReader reading from r.
+func NewPeekReader(r io.Reader) *PeekReader {
+	return &PeekReader{r: r}
+}
+
+// Peek returns the next n bytes without consuming them. If fewer than n
+// bytes are available, it returns those there are along with the error
+// that stopped it, io.EOF at the end of the input. The slice is only valid
+// until the next call to Read or Peek.
+func (p *PeekReader) Peek(n int) ([]byte, error) {
+	if cap(p.buf) < n {
+		buf := make([]byte, len(p.buf), n)
+		copy(buf, p.buf)
+		p.buf = buf
+	}
+	for len(p.buf) < n && p.err == nil {
+		var m int
+		m, p.err = p.r.Read(p.buf[len(p.buf):n])
+		p.buf = p.buf[:len(p.buf)+m]
+	}
+	if len(p.buf) < n {
+		return p.buf, p.err
+	}
+	return p.buf[:n], nil
+}
+
+// Read reads any peeked bytes first, then from the underlying reader.
+func (p *PeekReader) Read(b []byte) (int, error) {
+	if len(p.buf) > 0 {
+		n := copy(b, p.buf)
+		p.buf = p.buf[:copy(p.buf, p.buf[n:])]
+		return n, nil
+	}
+	if p.err != nil {
+		err := p.err
+		p.err = nil
+		return 0, err
+	}
+	return p.r.Read(b)
+}
+
+// Buffered returns the number of peeked bytes not yet read.
+func (p *PeekReader) Buffered() int {
+	return len(p.buf)
+}
+
+// discard consumes n bytes, which must already have been peeked
+func (p *PeekReader) discard(n int) {
+	p.buf = p.buf[:copy(p.buf, p.buf[n:])]
+}
+
+// unread returns b to the front of the input, to be read again
+func (p *PeekReader) unread(b []byte) {
+	p.buf = append(append(make([]byte, 0, len(b)+len(p.buf)), b...), p.buf...)
+}
@@ -0,0 +1,46 @@
+package cpio
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestPeekReader(t *testing.T) {
+	p := NewPeekReader(iotest.OneByteReader(strings.NewReader("070701rest")))
+	b, err := p.Peek(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "070701" {
+		t.Errorf("expected to peek 070701 but got %q", b)
+	}
+	intEq(t, "Buffered", 6, p.Buffered())
+
+	buf := make([]byte, 4)
+	n, err := p.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "0707" {
+		t.Errorf("expected to read 0707 but got %q", buf[:n])
+	}
+
+	b, err = p.Peek(20)
+	if err != io.EOF {
+		t.Error("expected io.EOF but got:", err)
+	}
+	if string(b) != "01rest" {
+		t.Errorf("expected to peek 01rest but got %q", b)
+	}
+
+	rest, err := ioutil.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "01rest" {
+		t.Errorf("expected to read 01rest but got %q", rest)
+	}
+}
@@ -0,0 +1,24 @@
+package cpio
+
+import "io"
+
+// PipeArchive runs build in a new goroutine with a Writer writing into a
+// pipe, and returns the read side of the pipe, for streaming an archive
+// without buffering it.
+//
+// The Writer is closed, writing the trailer, once build returns. If build or
+// Close fails, reads from the returned reader return that error after any
+// data already written. Closing the reader early causes further writes by
+// build to fail with io.ErrClosedPipe.
+func PipeArchive(build func(*Writer) error) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		w := NewWriter(pw)
+		err := build(w)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
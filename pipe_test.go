@@ -0,0 +1,43 @@
+package cpio
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPipeArchive(t *testing.T) {
+	rc, err := PipeArchive(func(w *Writer) error {
+		return w.AddReader(&Header{Name: "hello.txt", Mode: 0100644, NLink: 1, Size: 6, ModTime: testModTime}, strings.NewReader("world\n"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	r := NewReader(rc)
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "hello.txt" {
+		t.Errorf("expected name to be hello.txt but got %s", hdr.Name)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Error("expected io.EOF but got:", err)
+	}
+
+	buildErr := errors.New("build failed")
+	rc, err = PipeArchive(func(w *Writer) error {
+		return buildErr
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err = ioutil.ReadAll(rc); err != buildErr {
+		t.Error("expected the build error but got:", err)
+	}
+}
@@ -0,0 +1,28 @@
+package cpio
+
+// RawEntry is the header of an entry along with its encoding exactly as
+// read, returned by Reader.RawEntry. Writing it with Writer.WriteRaw
+// reproduces the original bytes unless Header has been changed.
+//
+// Changing any field of Header, including Encoding, causes the entry to be
+// re-encoded, which normalizes the formatting of every field and drops
+// anything in the name field after its NUL terminator. Comparison is as by
+// Header.Equal, so a ModTime in another location still counts as unchanged.
+// The data and the padding following it are not part of a RawEntry.
+type RawEntry struct {
+	Header Header
+
+	orig Header
+	raw  []byte
+}
+
+// Modified reports whether Header differs from the header as read.
+func (e *RawEntry) Modified() bool {
+	return !e.Header.Equal(&e.orig)
+}
+
+// Bytes returns the header as read, from the magic number through the
+// padding following the name. It must not be modified.
+func (e *RawEntry) Bytes() []byte {
+	return e.raw
+}
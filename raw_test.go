@@ -0,0 +1,81 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// rewriteRaw copies each entry of data with WriteRaw, calling edit first
+func rewriteRaw(t *testing.T, data []byte, edit func(*RawEntry)) []byte {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	r := NewReader(bytes.NewReader(data))
+	r.KeepRaw = true
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := r.RawEntry()
+		edit(e)
+		if err = w.WriteRaw(e); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.Copy(w, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRawEntry(t *testing.T) {
+	// written by bsdcpio, with lowercase hex digits that the Writer would
+	// normalize if the headers were re-encoded
+	data, err := ioutil.ReadFile("test-data/newc-multi.cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// everything up to the trailer is reproduced exactly
+	out := rewriteRaw(t, data, func(*RawEntry) {})
+	n := len(out) - int(headerSize(EncodingTypeASCIISVR4, len("TRAILER!!!")+1))
+	if !bytes.Equal(out[:n], data[:n]) {
+		t.Errorf("expected unmodified entries to be copied exactly:\nExpected: %q\nActual:   %q", data[:n], out[:n])
+	}
+
+	var edited string
+	out = rewriteRaw(t, data, func(e *RawEntry) {
+		if edited == "" {
+			edited = e.Header.Name
+			e.Header.UID = 1234
+		}
+		if !bytes.HasPrefix(e.Bytes(), []byte(MagicNewc)) {
+			t.Errorf("expected raw header to start with the magic number but got %q", e.Bytes())
+		}
+	})
+	r := NewReader(bytes.NewReader(out))
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != edited {
+		t.Errorf("expected first entry to be %s but got %s", edited, hdr.Name)
+	}
+	intEq(t, "UID", 1234, hdr.UID)
+	// the edited header is re-encoded, but the rest are untouched
+	if bytes.Equal(out[:HeaderSizeNewc], data[:HeaderSizeNewc]) {
+		t.Error("expected the edited header to be re-encoded")
+	}
+	second := headerSize(EncodingTypeASCIISVR4, len(hdr.Name)+1) + hdr.Size + dataPad(EncodingTypeASCIISVR4, hdr.Size)
+	if !bytes.Equal(out[second:n], data[second:n]) {
+		t.Error("expected entries after the edited one to be copied exactly")
+	}
+}
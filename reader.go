@@ -5,106 +5,645 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
-	"strconv"
+	"math"
+	"os"
 	"time"
 )
 
 var (
 	// ErrHeader is returned if the header was unable to be decoded
 	ErrHeader = errors.New("github.com/mastercactapus/gocpio: invalid cpio header")
+
+	// ErrMissingTrailer is returned if the input ends cleanly between entries
+	// without a trailer
+	ErrMissingTrailer = errors.New("github.com/mastercactapus/gocpio: archive ended without a trailer")
+
+	// SkipEntry may be returned by the function passed to ForEach to move on
+	// to the next entry without reading the rest of the current one.
+	SkipEntry = errors.New("github.com/mastercactapus/gocpio: skip this entry")
+
+	// ErrReadTimeout is returned when a read from the input does not complete
+	// within Reader.ReadTimeout
+	ErrReadTimeout = errors.New("github.com/mastercactapus/gocpio: read timed out")
+
+	// ErrInputTooLarge is returned once a Reader has read MaxInputSize bytes
+	// and more are needed
+	ErrInputTooLarge = errors.New("github.com/mastercactapus/gocpio: archive exceeds maximum input size")
+
+	// ErrNoArchive is returned by NextArchive when the bytes following a
+	// trailer do not lead to another archive
+	ErrNoArchive = errors.New("github.com/mastercactapus/gocpio: no archive found after trailer")
+
+	// ErrTruncatedBody is returned by CopyBody if the input ends before the
+	// entry's data does
+	ErrTruncatedBody = errors.New("github.com/mastercactapus/gocpio: entry data is truncated")
 )
 
+// maxArchiveGap is how many bytes other than zeros NextArchive skips
+// looking for the next archive
+const maxArchiveGap = 1 << 20
+
 // A Reader provides sequential access to the contents of a cpio archive.
 type Reader struct {
-	r     io.Reader
-	err   error
-	lr    io.Reader
-	buf   []byte
-	align int
+	// Strict causes Next to return ErrHeader for headers that do not exactly
+	// follow the format specification. By default the reader is tolerant of
+	// common deviations, such as newc headers written with lowercase hex digits.
+	Strict bool
+
+	// UnsetZeroModTime causes entries whose mtime field is exactly zero to be
+	// returned with a zero Header.ModTime rather than the Unix epoch, so that
+	// Header.HasModTime reports false for them.
+	UnsetZeroModTime bool
+
+	// AssumeRegular causes entries with data but no file type bits in their
+	// mode, as written by some buggy archivers, to be treated as regular files.
+	// By default the mode is returned as found.
+	AssumeRegular bool
+
+	// UnpaddedNames allows newc and crc archives from writers that do not pad
+	// names and data to 4 byte boundaries. When padding is expected but the
+	// bytes found are not zero, they are read as the start of the data or
+	// next header instead. By default non-zero name padding is reported as
+	// ErrHeader.
+	UnpaddedNames bool
+
+	// SpacePadded allows numeric header fields padded with spaces rather
+	// than leading zeros, as written by some older archivers. A field of
+	// only spaces is read as 0.
+	SpacePadded bool
+
+	// TruncatedTrailerPadding causes a trailer whose name padding is cut off
+	// by the end of the input to be accepted, returning io.EOF rather than
+	// io.ErrUnexpectedEOF, for archives that lost their final bytes in
+	// transfer.
+	TruncatedTrailerPadding bool
+
+	// ReadTimeout, if non-zero, limits how long each read from the input may
+	// take, by setting a read deadline before it. It requires an input with a
+	// SetReadDeadline(time.Time) error method, such as a net.Conn, and is
+	// ignored otherwise. ErrReadTimeout is returned when a deadline passes.
+	ReadTimeout time.Duration
+
+	// MaxInputSize, if non-zero, is the most bytes that will be read from the
+	// input, including headers, data and padding. Next and Read return
+	// ErrInputTooLarge once more are needed.
+	MaxInputSize int64
+
+	// Stats causes statistics about each entry's data to be collected as it
+	// is read, available from EntryStats.
+	Stats bool
+
+	// Hash, if set, is used to compute a digest of each entry's data as it
+	// is read, available from EntryDigest.
+	Hash func() hash.Hash
+
+	// KeepRaw causes the bytes of each header and name to be kept exactly as
+	// read, available from RawEntry.
+	KeepRaw bool
+
+	// SkipBufferSize is the size of the buffer used by Next to read through
+	// the rest of an entry's data when it can't seek past it. Zero means
+	// 32KiB.
+	SkipBufferSize int
+
+	// MaxReadChunk, if non-zero, is the most bytes of data a single call to
+	// Read returns, however large the buffer passed to it.
+	MaxReadChunk int
+
+	r       *PeekReader
+	in      *countReader
+	err     error
+	hdr     *Header
+	lr      *io.LimitedReader
+	digest  hash.Hash
+	stats   EntryStats
+	buf     []byte
+	skipBuf []byte
+	readN   int
+	align   int
+	raw     []byte
+
+	trailer bool
+	links   map[linkKey]bool
+}
+
+// linkKey identifies a file with multiple links in an archive
+type linkKey struct {
+	devMajor, devMinor, inode int
 }
 
 // NewReader creates a new Reader reading from r.
 func NewReader(r io.Reader) *Reader {
-	return &Reader{r: r, buf: make([]byte, 0, 32768)}
+	in := &countReader{r: r}
+	return &Reader{r: NewPeekReader(in), in: in, buf: make([]byte, 0, 32768)}
+}
+
+// countReader counts the bytes read through it, failing with
+// ErrInputTooLarge once max is reached
+type countReader struct {
+	r       io.Reader
+	n       int64
+	max     int64
+	size    int64 // size of a seekable input, or 0 until known
+	timeout time.Duration
+}
+
+func (c *countReader) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if d, ok := c.r.(interface{ SetReadDeadline(time.Time) error }); ok {
+			err := d.SetReadDeadline(time.Now().Add(c.timeout))
+			if err != nil {
+				return 0, err
+			}
+			n, err := c.read(b)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = ErrReadTimeout
+			}
+			return n, err
+		}
+	}
+	return c.read(b)
+}
+
+func (c *countReader) read(b []byte) (int, error) {
+	if c.max > 0 {
+		if c.n >= c.max && len(b) > 0 {
+			return 0, ErrInputTooLarge
+		}
+		if int64(len(b)) > c.max-c.n {
+			b = b[:c.max-c.n]
+		}
+	}
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewReaderAt creates a new Reader reading the archive that starts at offset
+// within r, such as an initramfs appended to another file. Reading stops at
+// the archive's trailer, and Remainder then reads what follows it in r.
+func NewReaderAt(r io.ReaderAt, offset int64) *Reader {
+	return NewReader(io.NewSectionReader(r, offset, math.MaxInt64-offset))
 }
 
 // Read reads from the current entry in the cpio archive.
 //
 // It returns 0, io.EOF when it reaches the end of that entry,
-// until Next is called to advance to the next entry.
+// until Next is called to advance to the next entry. If the input ends
+// first, io.ErrUnexpectedEOF is returned, as it is by later calls to Next.
 func (cr *Reader) Read(b []byte) (int, error) {
 	if cr.err != nil {
 		return 0, cr.err
 	}
+	cr.in.max = cr.MaxInputSize
+	cr.in.timeout = cr.ReadTimeout
 	if cr.lr == nil {
 		return 0, io.EOF
 	}
+	if cr.MaxReadChunk > 0 && len(b) > cr.MaxReadChunk {
+		b = b[:cr.MaxReadChunk]
+	}
 	n, err := cr.lr.Read(b)
-	if err != nil {
-		if err != io.EOF {
-			cr.err = err
-		} else {
-			cr.lr = nil
-		}
+	if cr.digest != nil {
+		cr.digest.Write(b[:n])
+	}
+	if cr.Stats {
+		cr.stats.add(b[:n])
+	}
+	if err == io.EOF && cr.lr.N > 0 {
+		// the input ended before the entry's data did
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		cr.err = err
 	}
 	return n, err
 }
 
 // Next advances to the next entry in the cpio archive.
 //
-// io.EOF is returned at the end of the input.
+// io.EOF is returned when the trailer is reached. If the input ends between
+// entries without a trailer, ErrMissingTrailer is returned instead, and
+// io.ErrUnexpectedEOF is returned if it ends partway through a header or
+// an entry's data.
 func (cr *Reader) Next() (*Header, error) {
 	if cr.err != nil {
 		return nil, cr.err
 	}
+	cr.in.max = cr.MaxInputSize
+	cr.in.timeout = cr.ReadTimeout
 
-	if cr.lr != nil {
-		// skip through current file data
-		_, cr.err = io.Copy(ioutil.Discard, cr)
-		if cr.err != nil {
-			return nil, cr.err
-		}
+	if cr.skipBody() != nil {
+		return nil, cr.err
 	}
+	cr.hdr = nil
+	cr.lr = nil
 
-	cr.buf = cr.buf[:2+cr.align]
-	_, cr.err = io.ReadFull(cr.r, cr.buf)
-	if cr.err != nil {
+	if cr.skipAlign() != nil {
 		return nil, cr.err
 	}
-	cr.buf = cr.buf[cr.align:]
 
+	magic, err := cr.r.Peek(len(MagicNewc))
 	switch {
-	case bytes.Equal(cr.buf, []byte{0x71, 0xc7}): // binary, big-endian
+	case len(magic) == 0 && err == io.EOF:
+		cr.err = ErrMissingTrailer
+	case len(magic) < 2 && err == io.EOF:
+		cr.err = io.ErrUnexpectedEOF
+	case len(magic) < 2:
+		cr.err = err
+	case bytes.HasPrefix(magic, []byte{0x71, 0xc7}): // binary, big-endian
 		return cr.nextBinary(binary.BigEndian, EncodingTypeBinaryBE)
-	case bytes.Equal(cr.buf, []byte{0xc7, 0x71}): // binary, little-endian
+	case bytes.HasPrefix(magic, []byte{0xc7, 0x71}): // binary, little-endian
 		return cr.nextBinary(binary.LittleEndian, EncodingTypeBinaryLE)
-	case bytes.Equal(cr.buf, []byte{0x30, 0x37}): //ascii
-		return cr.nextASCII()
+	case !bytes.HasPrefix(magic, []byte("07")):
+		cr.err = fmt.Errorf("%w: magic % #x", ErrHeader, magic[:2])
+	case len(magic) < len(MagicNewc) && err == io.EOF:
+		cr.err = io.ErrUnexpectedEOF
+	case len(magic) < len(MagicNewc):
+		cr.err = err
+	case string(magic) == MagicODC:
+		return cr.nextASCIISUSv2()
+	case string(magic) == MagicNewc:
+		return cr.nextASCIISVR4(EncodingTypeASCIISVR4)
+	case string(magic) == MagicCRC:
+		return cr.nextASCIISVR4(EncodingTypeASCIISVR4CRC)
 	default:
-		cr.err = ErrHeader
-		return nil, cr.err
+		cr.err = fmt.Errorf("%w: magic % #x", ErrHeader, magic)
 	}
+	return nil, cr.err
 }
 
-func (cr *Reader) nextASCII() (*Header, error) {
-	cr.buf = cr.buf[:4]
-	_, cr.err = io.ReadFull(cr.r, cr.buf)
-	if cr.err != nil {
-		return nil, cr.err
+// NextContent advances to the next entry like Next, but skips hard link
+// placeholders: regular files with a Size of 0 and an NLink greater than 1
+// whose device and inode were already seen in an earlier entry with an NLink
+// greater than 1. Directories, symlinks and all other entries are returned.
+// Entries with an Inode of 0, used by GNU cpio for synthetic entries, are
+// never treated as links of each other.
+//
+// In newc archives written by GNU cpio, the data of a hard linked file comes
+// with its last link, so the first placeholder is still returned.
+func (cr *Reader) NextContent() (*Header, error) {
+	for {
+		hdr, err := cr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Mode&^07777 != modeRegular || hdr.NLink < 2 || hdr.Inode == 0 {
+			return hdr, nil
+		}
+		key := linkKey{hdr.DevMajor, hdr.DevMinor, hdr.Inode}
+		if hdr.Size == 0 && cr.links[key] {
+			continue
+		}
+		if cr.links == nil {
+			cr.links = make(map[linkKey]bool)
+		}
+		cr.links[key] = true
+		return hdr, nil
+	}
+}
+
+// ForEach calls fn for each remaining entry in the archive with a reader
+// bounded to the entry's data, until the trailer is reached. Any data fn does
+// not read is skipped before the next entry.
+//
+// If fn returns SkipEntry, iteration continues with the next entry. Any
+// other error stops iteration and is returned by ForEach.
+func (cr *Reader) ForEach(fn func(hdr *Header, body io.Reader) error) error {
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(hdr, cr)
+		if err != nil && err != SkipEntry {
+			return err
+		}
+	}
+}
+
+// skipBody skips the rest of the current entry's data, if any
+func (cr *Reader) skipBody() error {
+	if cr.lr != nil && !cr.seekPast() {
+		// skip through current file data
+		size := cr.SkipBufferSize
+		if size <= 0 {
+			size = 32 * 1024
+		}
+		if len(cr.skipBuf) != size {
+			cr.skipBuf = make([]byte, size)
+		}
+		// hide Discard's ReadFrom so that skipBuf is used
+		_, cr.err = io.CopyBuffer(struct{ io.Writer }{ioutil.Discard}, cr, cr.skipBuf)
+	}
+	return cr.err
+}
+
+// Open advances to the next entry like Next, and returns its data as a
+// separate io.ReadCloser. Closing it skips any unread data, as Next would.
+// The body may not be read once closed, or once the Reader has moved on to
+// another entry.
+func (cr *Reader) Open() (io.ReadCloser, *Header, error) {
+	hdr, err := cr.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &entryBody{cr: cr, hdr: hdr}, hdr, nil
+}
+
+// entryBody is the data of a single entry, as returned by Open
+type entryBody struct {
+	cr     *Reader
+	hdr    *Header
+	closed bool
+}
+
+func (b *entryBody) Read(p []byte) (int, error) {
+	if b.closed || b.cr.hdr != b.hdr {
+		return 0, errors.New("github.com/mastercactapus/gocpio: read of closed entry")
+	}
+	return b.cr.Read(p)
+}
+
+func (b *entryBody) Close() error {
+	if b.closed || b.cr.hdr != b.hdr {
+		b.closed = true
+		return nil
+	}
+	b.closed = true
+	return b.cr.skipBody()
+}
+
+// NextN advances past n-1 entries without reading their data, seeking past
+// it when the input allows, and returns the header of the nth. It returns
+// io.EOF if the trailer is reached first, and an error if n is less than 1.
+func (cr *Reader) NextN(n int) (*Header, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("github.com/mastercactapus/gocpio: NextN called with n = %d", n)
+	}
+	for ; n > 1; n-- {
+		_, err := cr.Next()
+		if err != nil {
+			return nil, err
+		}
 	}
+	return cr.Next()
+}
+
+// skipAlign skips the padding after the previous entry's data, leaving
+// anything but zeros to be read as the next header if padding may be missing
+func (cr *Reader) skipAlign() error {
+	if cr.align == 0 {
+		return cr.err
+	}
+	pad, err := cr.r.Peek(cr.align)
 	switch {
-	case bytes.Equal(cr.buf, []byte{0x30, 0x37, 0x30, 0x37}): // SUSv2
-		return cr.nextASCIISUSv2()
-	case bytes.Equal(cr.buf, []byte{0x30, 0x37, 0x30, 0x31}): // SVR4
-		return cr.nextASCIISVR4(EncodingTypeASCIISVR4)
-	case bytes.Equal(cr.buf, []byte{0x30, 0x37, 0x30, 0x32}): // SVR4CRC
-		return cr.nextASCIISVR4(EncodingTypeASCIISVR4CRC)
-	default:
-		cr.err = ErrHeader
-		return nil, cr.err
+	case len(pad) == 0 && err == io.EOF:
+		cr.err = ErrMissingTrailer
+	case len(pad) < cr.align && err == io.EOF:
+		cr.err = io.ErrUnexpectedEOF
+	case len(pad) < cr.align:
+		cr.err = err
+	case !cr.UnpaddedNames || isZero(pad):
+		cr.r.discard(cr.align)
 	}
+	cr.align = 0
+	return cr.err
+}
+
+// CopyBody copies the rest of the current entry's data to dst, along with
+// its padding, returning the number of bytes copied. A body shorter than the
+// header's Size is reported with an error wrapping ErrTruncatedBody in place
+// of the io.ErrUnexpectedEOF from Read, and the error is kept for later
+// calls to Next.
+func (cr *Reader) CopyBody(dst io.Writer) (int64, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.lr == nil {
+		return 0, io.EOF
+	}
+	want := cr.lr.N
+	n, err := io.Copy(dst, cr)
+	if err == io.ErrUnexpectedEOF {
+		cr.err = fmt.Errorf("%w: %q has %d of %d bytes", ErrTruncatedBody, cr.hdr.Name, cr.hdr.Size-want+n, cr.hdr.Size)
+		return n, cr.err
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, cr.skipAlign()
+}
+
+// seekPast skips the rest of the current entry's data by seeking, if the
+// input supports it and the data needn't pass through Read. It reports
+// whether the data was skipped, setting cr.err on failure.
+func (cr *Reader) seekPast() bool {
+	n := cr.lr.N
+	s, ok := cr.in.r.(io.Seeker)
+	if !ok || n == 0 || cr.r.Buffered() > 0 || cr.digest != nil || cr.Stats ||
+		(cr.in.max > 0 && cr.in.n+n > cr.in.max) {
+		return false
+	}
+	pos, err := s.Seek(n, io.SeekCurrent)
+	if err != nil {
+		// the input may only look seekable, such as a pipe as an *os.File
+		return false
+	}
+	if cr.in.size == 0 {
+		cr.in.size, cr.err = s.Seek(0, io.SeekEnd)
+		if cr.err == nil {
+			_, cr.err = s.Seek(pos, io.SeekStart)
+		}
+		if cr.err != nil {
+			return true
+		}
+	}
+	cr.in.n += n
+	cr.lr.N = 0
+	if pos > cr.in.size {
+		cr.err = io.ErrUnexpectedEOF
+	}
+	return true
+}
+
+// Headers reads the headers of the remaining entries up to the trailer,
+// skipping their data. When the input is an io.Seeker, such as an *os.File,
+// the data is skipped by seeking rather than reading.
+func (cr *Reader) Headers() ([]*Header, error) {
+	var hdrs []*Header
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return hdrs, nil
+		}
+		if err != nil {
+			return hdrs, err
+		}
+		hdrs = append(hdrs, hdr)
+	}
+}
+
+// InputOffset returns the number of bytes read from the input so far.
+func (cr *Reader) InputOffset() int64 {
+	return cr.in.n - int64(cr.r.Buffered())
+}
+
+// Current returns the header most recently returned by Next, whose body
+// Read is serving. It does not advance the reader.
+//
+// Current returns nil before the first call to Next and once Next
+// has returned an error, including io.EOF.
+func (cr *Reader) Current() *Header {
+	return cr.hdr
+}
+
+// readFull fills b from partway through a header, where reaching the end
+// of the input is always unexpected.
+func (cr *Reader) readFull(b []byte) error {
+	cr.readN, cr.err = io.ReadFull(cr.r, b)
+	if cr.err == io.EOF {
+		cr.err = io.ErrUnexpectedEOF
+	}
+	return cr.err
+}
+
+// keep records b as part of the raw header if KeepRaw is set
+func (cr *Reader) keep(b []byte) {
+	if cr.KeepRaw {
+		cr.raw = append(cr.raw, b...)
+	}
+}
+
+// RawEntry returns the current entry's header along with its bytes exactly
+// as read, for writing back unchanged with Writer.WriteRaw. It returns nil
+// if KeepRaw was not set when the entry was read, or before the first call
+// to Next.
+func (cr *Reader) RawEntry() *RawEntry {
+	if cr.hdr == nil || !cr.KeepRaw || len(cr.raw) == 0 {
+		return nil
+	}
+	return &RawEntry{
+		Header: *cr.hdr,
+		orig:   *cr.hdr,
+		raw:    append([]byte(nil), cr.raw...),
+	}
+}
+
+// SawTrailer reports whether Next has reached the archive's trailer, so that
+// its io.EOF marks a complete archive rather than a truncated stream.
+func (cr *Reader) SawTrailer() bool {
+	return cr.trailer
+}
+
+// Remainder returns a reader over the input following the trailer and its
+// padding, such as block padding or further concatenated archives.
+// It returns nil until Next has returned io.EOF for the trailer.
+func (cr *Reader) Remainder() io.Reader {
+	if !cr.trailer {
+		return nil
+	}
+	return cr.r
+}
+
+// NextArchive moves on to another archive following the trailer of the
+// current one, as in concatenated initramfs images, so that Next reads its
+// entries. It returns the number of bytes skipped to reach it.
+//
+// Zero padding after the trailer is skipped. If other bytes are found, up to
+// 1MiB is scanned for the magic number of the next archive, returning
+// ErrNoArchive if none is found. io.EOF is returned if only zeros remain.
+// NextArchive may only be called once Next has returned io.EOF for a trailer.
+func (cr *Reader) NextArchive() (int64, error) {
+	if !cr.trailer {
+		return 0, errors.New("github.com/mastercactapus/gocpio: NextArchive called before the trailer")
+	}
+
+	var skipped int64
+	var junk bool
+	buf := make([]byte, 0, 32768)
+	for {
+		n, err := cr.r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if i := magicIndex(buf); i >= 0 {
+			cr.r.unread(buf[i:])
+			skipped += int64(i)
+			cr.trailer = false
+			cr.err = nil
+			cr.hdr = nil
+			cr.lr = nil
+			cr.links = nil
+			return skipped, nil
+		}
+		junk = junk || !isZero(buf)
+
+		// keep enough to find a magic number split across reads
+		keep := len(MagicNewc) - 1
+		if keep > len(buf) {
+			keep = len(buf)
+		}
+		skipped += int64(len(buf) - keep)
+		buf = buf[:copy(buf, buf[len(buf)-keep:])]
+
+		switch {
+		case err == io.EOF && !junk:
+			return skipped + int64(len(buf)), io.EOF
+		case err == io.EOF:
+			return skipped + int64(len(buf)), ErrNoArchive
+		case err != nil:
+			cr.err = err
+			return skipped, err
+		case junk && skipped > maxArchiveGap:
+			return skipped, fmt.Errorf("%w: none within %d bytes", ErrNoArchive, skipped)
+		}
+	}
+}
+
+// magicIndex returns the position of the first magic number in b, or -1
+func magicIndex(b []byte) int {
+	for i := 0; i+1 < len(b); i++ {
+		switch {
+		case b[i] == 0x71 && b[i+1] == 0xc7, b[i] == 0xc7 && b[i+1] == 0x71:
+			return i
+		case i+len(MagicNewc) <= len(b):
+			switch string(b[i : i+len(MagicNewc)]) {
+			case MagicODC, MagicNewc, MagicCRC:
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// EntryOffset returns the number of bytes of the current entry's data
+// returned by Read so far, from 0 just after Next up to the entry's Size.
+// It returns 0 if there is no current entry.
+func (cr *Reader) EntryOffset() int64 {
+	if cr.hdr == nil {
+		return 0
+	}
+	return cr.hdr.Size - cr.lr.N
+}
+
+// EntryStats returns statistics about the data of the current entry read so
+// far. It returns zero statistics if Stats is not set.
+func (cr *Reader) EntryStats() EntryStats {
+	return cr.stats
+}
+
+// EntryDigest returns the digest of the current entry's data computed with
+// Hash. It returns nil if Hash is not set or the data has not been fully read.
+func (cr *Reader) EntryDigest() []byte {
+	if cr.digest == nil || cr.lr == nil || cr.lr.N > 0 {
+		return nil
+	}
+	return cr.digest.Sum(nil)
 }
 
 func (cr *Reader) parseInt(dst *int, b []byte, base int) {
@@ -112,45 +651,179 @@ func (cr *Reader) parseInt(dst *int, b []byte, base int) {
 		return
 	}
 	var i int64
-	i, cr.err = strconv.ParseInt(string(b), base, 64)
-	if cr.err != nil {
+	cr.parseInt64(&i, b, base)
+	if cr.err == nil && int64(int(i)) != i {
+		cr.err = fmt.Errorf("%w: integer overflow on token %s", ErrHeader, b)
 		return
 	}
 	*dst = int(i)
 }
+func (cr *Reader) parseUint32(dst *uint32, b []byte, base int) {
+	if cr.err != nil {
+		return
+	}
+	var i int64
+	cr.parseInt64(&i, b, base)
+	if i > math.MaxUint32 {
+		cr.err = ErrHeader
+		return
+	}
+	*dst = uint32(i)
+}
 func (cr *Reader) parseInt64(dst *int64, b []byte, base int) {
 	if cr.err != nil {
 		return
 	}
-	*dst, cr.err = strconv.ParseInt(string(b), base, 64)
+	if cr.SpacePadded {
+		b = bytes.Trim(b, " ")
+		if len(b) == 0 {
+			*dst = 0
+			return
+		}
+	}
+	*dst, cr.err = parseField(b, base)
+}
+
+// ParseOctalField parses a fixed-width octal header field, as used by the
+// odc format. ErrHeader is returned if b is empty or holds anything other
+// than octal digits.
+func ParseOctalField(b []byte) (int64, error) {
+	return parseField(b, 8)
+}
+
+// ParseHexField parses a fixed-width hexadecimal header field, as used by
+// the newc and crc formats. Both upper and lowercase digits are accepted.
+// ErrHeader is returned if b is empty or holds anything other than hex digits.
+func ParseHexField(b []byte) (int64, error) {
+	return parseField(b, 16)
+}
+
+// parseField parses b as an unsigned integer in base 8 or 16 without allocating
+func parseField(b []byte, base int) (int64, error) {
+	if len(b) == 0 {
+		return 0, ErrHeader
+	}
+	var n uint64
+	for _, c := range b {
+		var d byte
+		switch {
+		case '0' <= c && c <= '9':
+			d = c - '0'
+		case 'a' <= c && c <= 'f':
+			d = c - 'a' + 10
+		case 'A' <= c && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, ErrHeader
+		}
+		if int(d) >= base || n > (math.MaxInt64-uint64(d))/uint64(base) {
+			return 0, ErrHeader
+		}
+		n = n*uint64(base) + uint64(d)
+	}
+	return int64(n), nil
+}
+
+func (cr *Reader) modTime(sec int64) time.Time {
+	if sec == 0 && cr.UnsetZeroModTime {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
 }
 
 func (cr *Reader) nextASCIISUSv2() (*Header, error) {
-	var modTime int64
+	cr.buf = cr.buf[:HeaderSizeODC]
+	if cr.readFull(cr.buf) != nil {
+		return nil, cr.err
+	}
+	cr.raw = cr.raw[:0]
+	cr.keep(cr.buf)
+
+	hdr, nameSize := cr.parseODC(cr.buf[len(MagicODC):], 6)
+	if cr.err == nil && !cr.nameTerminated(0, nameSize) {
+		// Some tools write eight digits in place of each six-digit field;
+		// if the name only lines up that way, read it as such.
+		wide, err := cr.r.Peek(headerSizeWideODC - HeaderSizeODC)
+		if err == nil {
+			b := append(cr.buf[len(MagicODC):len(cr.buf):len(cr.buf)], wide...)
+			whdr, wnameSize := cr.parseODC(b, 8)
+			if cr.err == nil && wnameSize <= maxNameSize && cr.nameTerminated(len(wide), wnameSize) {
+				cr.keep(wide)
+				cr.r.discard(len(wide))
+				return cr.nextName(whdr, wnameSize)
+			}
+		}
+		// otherwise read the name as is, unless Strict
+		cr.err = nil
+		if cr.Strict {
+			cr.err = fmt.Errorf("%w: odc name of %d bytes is not NUL-terminated; the header may not use standard field widths", ErrHeader, nameSize)
+		}
+	}
+
+	return cr.nextName(hdr, nameSize)
+}
+
+// headerSizeWideODC is the size of an odc header written with eight digits
+// for each of the fields that should have six.
+const headerSizeWideODC = HeaderSizeODC + 8*2
+
+// parseODC parses the fields of an odc header following the magic, where w
+// is the width of the fields that are normally six digits.
+func (cr *Reader) parseODC(b []byte, w int) (*Header, int) {
+	field := func(n int) []byte {
+		f := b[:n]
+		b = b[n:]
+		return f
+	}
+
+	var modTime, dev, rdev int64
 	var nameSize int
 	hdr := &Header{Encoding: EncodingTypeASCIISUSv2}
-	_, cr.err = fmt.Fscanf(cr.r, "%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o",
-		&hdr.DevMinor,
-		&hdr.Inode,
-		&hdr.Mode,
-		&hdr.UID,
-		&hdr.GID,
-		&hdr.NLink,
-		&hdr.RDevMinor,
-		&modTime,
-		&nameSize,
-		&hdr.Size,
-	)
-	hdr.ModTime = time.Unix(modTime, 0)
+	cr.parseInt64(&dev, field(w), 8)
+	cr.parseInt(&hdr.Inode, field(w), 8)
+	cr.parseInt64(&hdr.Mode, field(w), 8)
+	cr.parseInt(&hdr.UID, field(w), 8)
+	cr.parseInt(&hdr.GID, field(w), 8)
+	cr.parseInt(&hdr.NLink, field(w), 8)
+	cr.parseInt64(&rdev, field(w), 8)
+	cr.parseInt64(&modTime, field(11), 8)
+	cr.parseInt(&nameSize, field(w), 8)
+	cr.parseInt64(&hdr.Size, field(11), 8)
+	hdr.ModTime = cr.modTime(modTime)
+	hdr.DevMajor, hdr.DevMinor = unpackDev(dev)
+	hdr.RDevMajor, hdr.RDevMinor = unpackDev(rdev)
+	return hdr, nameSize
+}
 
-	return cr.nextName(hdr, nameSize)
+// nameTerminated reports whether the name of nameSize bytes that starts skip
+// bytes into the unread input ends in a NUL. It reports true if the name
+// can't be peeked, leaving any error to be found when it is read.
+func (cr *Reader) nameTerminated(skip, nameSize int) bool {
+	if nameSize <= 0 {
+		return false
+	}
+	b, err := cr.r.Peek(skip + nameSize)
+	if err != nil {
+		return true
+	}
+	return b[len(b)-1] == 0
 }
 
+// maxNameSize is the longest name accepted by the Reader, far beyond any
+// real path, so that a corrupt name size isn't taken as a request to
+// allocate gigabytes
+const maxNameSize = 1 << 20
+
 func (cr *Reader) nextName(hdr *Header, p int) (*Header, error) {
 	if cr.err != nil {
 		return nil, cr.err
 	}
+	if p < 0 || int64(p) > maxField(hdr.Encoding) || p > maxNameSize {
+		cr.err = fmt.Errorf("%w: name size %d", ErrHeader, p)
+		return nil, cr.err
+	}
 	var rem int
+	nameSize := p
 	switch hdr.Encoding {
 	case EncodingTypeBinaryLE, EncodingTypeBinaryBE:
 		p += p % 2
@@ -160,7 +833,8 @@ func (cr *Reader) nextName(hdr *Header, p int) (*Header, error) {
 		if rem > 0 {
 			p += 4 - rem
 		}
-		rem = int((hdr.Size + int64(rem)) % 4)
+		// the name padding aligns the body, so only its own length matters
+		rem = int(hdr.Size % 4)
 		if rem > 0 {
 			cr.align = 4 - rem
 		} else {
@@ -176,67 +850,140 @@ func (cr *Reader) nextName(hdr *Header, p int) (*Header, error) {
 		cr.buf = cr.buf[:p]
 	}
 
-	_, cr.err = io.ReadFull(cr.r, cr.buf)
-	if cr.err != nil {
+	svr4 := hdr.Encoding == EncodingTypeASCIISVR4 || hdr.Encoding == EncodingTypeASCIISVR4CRC
+	if svr4 && cr.UnpaddedNames {
+		// the padding may be missing at the end of the input
+		if cr.readFull(cr.buf[:nameSize]) != nil {
+			return nil, cr.err
+		}
+		cr.keep(cr.buf[:nameSize])
+		pad, err := cr.r.Peek(p - nameSize)
+		if err != nil && err != io.EOF {
+			cr.err = err
+			return nil, cr.err
+		}
+		if len(pad) == p-nameSize && isZero(pad) {
+			cr.keep(pad)
+			cr.r.discard(len(pad))
+		}
+	} else if cr.readFull(cr.buf) != nil && !cr.shortTrailer(hdr, nameSize) {
+		return nil, cr.err
+	} else if svr4 && !isZero(cr.buf[nameSize:]) {
+		cr.err = fmt.Errorf("%w: non-zero padding after name %q", ErrHeader, cr.buf[:nameSize])
 		return nil, cr.err
+	} else {
+		cr.keep(cr.buf)
 	}
+	cr.buf = cr.buf[:nameSize]
 	p = bytes.IndexByte(cr.buf, 0)
 	if p == -1 {
 		hdr.Name = string(cr.buf)
 	} else {
 		hdr.Name = string(cr.buf[:p])
 	}
+	if cr.AssumeRegular && hdr.Mode&^07777 == 0 && hdr.Size > 0 {
+		hdr.Mode |= modeRegular
+	}
 	if hdr.Name == "TRAILER!!!" && hdr.Size == 0 {
-		return nil, io.EOF
+		cr.trailer = true
+		cr.err = io.EOF
+		return nil, cr.err
 	}
 
-	cr.lr = io.LimitReader(cr.r, hdr.Size)
+	cr.lr = &io.LimitedReader{R: cr.r, N: hdr.Size}
+	cr.hdr = hdr
+	cr.digest = nil
+	cr.stats = EntryStats{}
+	if cr.Hash != nil {
+		cr.digest = cr.Hash()
+	}
 	return hdr, nil
 }
 
+// shortTrailer reports whether a failed read of the name and its padding
+// is a trailer missing only its padding, allowed by TruncatedTrailerPadding,
+// and if so clears the error.
+func (cr *Reader) shortTrailer(hdr *Header, nameSize int) bool {
+	if !cr.TruncatedTrailerPadding || cr.err != io.ErrUnexpectedEOF || hdr.Size != 0 {
+		return false
+	}
+	if cr.readN < nameSize || string(cr.buf[:nameSize]) != "TRAILER!!!\x00" {
+		return false
+	}
+	cr.err = nil
+	for i := range cr.buf[nameSize:] {
+		cr.buf[nameSize+i] = 0
+	}
+	return true
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 func (cr *Reader) nextASCIISVR4(encoding EncodingType) (*Header, error) {
+	cr.buf = cr.buf[:HeaderSizeNewc]
+	if cr.readFull(cr.buf) != nil {
+		return nil, cr.err
+	}
+	cr.raw = cr.raw[:0]
+	cr.keep(cr.buf)
+	b := cr.buf[len(MagicNewc):]
+	if cr.Strict && bytes.ContainsAny(b, "abcdef") {
+		// the spec calls for uppercase hex digits
+		cr.err = ErrHeader
+		return nil, cr.err
+	}
+
 	var modTime int64
 	var nameSize int
 	hdr := &Header{Encoding: encoding}
-	_, cr.err = fmt.Fscanf(cr.r, "%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
-		&hdr.Inode,
-		&hdr.Mode,
-		&hdr.UID,
-		&hdr.GID,
-		&hdr.NLink,
-		&modTime,
-		&hdr.Size,
-		&hdr.DevMajor,
-		&hdr.DevMinor,
-		&hdr.RDevMajor,
-		&hdr.RDevMinor,
-		&nameSize,
-		&hdr.Checksum,
-	)
-	hdr.ModTime = time.Unix(modTime, 0)
+	cr.parseInt(&hdr.Inode, b[0:8], 16)
+	cr.parseInt64(&hdr.Mode, b[8:16], 16)
+	cr.parseInt(&hdr.UID, b[16:24], 16)
+	cr.parseInt(&hdr.GID, b[24:32], 16)
+	cr.parseInt(&hdr.NLink, b[32:40], 16)
+	cr.parseInt64(&modTime, b[40:48], 16)
+	cr.parseInt64(&hdr.Size, b[48:56], 16)
+	cr.parseInt(&hdr.DevMajor, b[56:64], 16)
+	cr.parseInt(&hdr.DevMinor, b[64:72], 16)
+	cr.parseInt(&hdr.RDevMajor, b[72:80], 16)
+	cr.parseInt(&hdr.RDevMinor, b[80:88], 16)
+	cr.parseInt(&nameSize, b[88:96], 16)
+	cr.parseUint32(&hdr.Checksum, b[96:104], 16)
+	hdr.ModTime = cr.modTime(modTime)
 
 	return cr.nextName(hdr, nameSize)
 }
 
 func (cr *Reader) nextBinary(order binary.ByteOrder, enc EncodingType) (*Header, error) {
-	var h binaryHeader
-	cr.err = binary.Read(cr.r, order, &h)
-	if cr.err != nil {
+	cr.buf = cr.buf[:HeaderSizeBinary]
+	if cr.readFull(cr.buf) != nil {
 		return nil, cr.err
 	}
+	cr.raw = cr.raw[:0]
+	cr.keep(cr.buf)
+	var h binaryHeader
+	binary.Read(bytes.NewReader(cr.buf[2:]), order, &h)
 
 	hdr := &Header{
-		Encoding:  enc,
-		DevMinor:  int(h.Dev),
-		Inode:     int(h.Inode),
-		Mode:      int64(h.Mode),
-		UID:       int(h.UID),
-		GID:       int(h.GID),
-		NLink:     int(h.NLink),
-		RDevMinor: int(h.RDev),
-		ModTime:   time.Unix(65536*int64(h.ModTime[0])+int64(h.ModTime[1]), 0),
-		Size:      65536*int64(h.Filesize[0]) + int64(h.Filesize[1]),
+		Encoding: enc,
+		Inode:    int(h.Inode),
+		Mode:     int64(h.Mode),
+		UID:      int(h.UID),
+		GID:      int(h.GID),
+		NLink:    int(h.NLink),
+		ModTime:  cr.modTime(65536*int64(h.ModTime[0]) + int64(h.ModTime[1])),
+		Size:     65536*int64(h.Filesize[0]) + int64(h.Filesize[1]),
 	}
 
+	hdr.DevMajor, hdr.DevMinor = unpackDev(int64(h.Dev))
+	hdr.RDevMajor, hdr.RDevMinor = unpackDev(int64(h.RDev))
+
 	return cr.nextName(hdr, int(h.Namesize))
 }
@@ -1,9 +1,15 @@
 package cpio
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,12 +34,18 @@ func testReaderType(t *testing.T, file string, enc EncodingType) *Header {
 		defer fd.Close()
 
 		r := NewReader(fd)
+		if r.Current() != nil {
+			t.Error("expected no current header before Next")
+		}
 
 		hdr, err := r.Next()
 		if err != nil {
 			t.Fatal("read first header:", err)
 		}
 		fileHeader = hdr
+		if r.Current() != hdr {
+			t.Error("expected Current to return the header from Next")
+		}
 
 		intEq(t, "DevMajor", 0, hdr.DevMajor)
 		intEq(t, "DevMinor", 44, hdr.DevMinor)
@@ -67,6 +79,9 @@ func testReaderType(t *testing.T, file string, enc EncodingType) *Header {
 		if err != io.EOF {
 			t.Error("expected io.EOF after last entry but got:", err, hdr)
 		}
+		if r.Current() != nil {
+			t.Error("expected no current header after the trailer")
+		}
 	})
 	return fileHeader
 }
@@ -75,7 +90,891 @@ func TestReader(t *testing.T) {
 	testReaderType(t, "test-data/ascii-susv2.cpio", EncodingTypeASCIISUSv2)
 	testReaderType(t, "test-data/ascii-svr4.cpio", EncodingTypeASCIISVR4)
 	hdr := testReaderType(t, "test-data/ascii-svr4-crc.cpio", EncodingTypeASCIISVR4CRC)
-	intEq(t, "Checksum", 562, hdr.Checksum)
+	intEq(t, "Checksum", 562, int(hdr.Checksum))
 	testReaderType(t, "test-data/binary.cpio", EncodingTypeBinaryLE)
 
 }
+
+func TestReaderLowercaseHex(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	err := w.WriteHeader(&Header{
+		Encoding: EncodingTypeASCIISVR4,
+		Inode:    0xabc,
+		Mode:     0100664,
+		NLink:    1,
+		Size:     6,
+		Name:     "hello.txt",
+		ModTime:  testModTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "world\n")
+	w.Close()
+
+	// lowercase the hex fields of the first header, as some producers do
+	data := buf.Bytes()
+	copy(data[6:110], bytes.ToLower(data[6:110]))
+
+	hdr, err := NewReader(bytes.NewReader(data)).Next()
+	if err != nil {
+		t.Fatal("read lowercase header:", err)
+	}
+	intEq(t, "Inode", 0xabc, hdr.Inode)
+	intEq(t, "Mode", 0100664, int(hdr.Mode))
+
+	r := NewReader(bytes.NewReader(data))
+	r.Strict = true
+	_, err = r.Next()
+	if err != ErrHeader {
+		t.Errorf("expected ErrHeader in strict mode but got: %v", err)
+	}
+}
+
+func TestReaderUnsetZeroModTime(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteHeader(&Header{Name: "epoch", Mode: 0100644, NLink: 1, ModTime: time.Unix(0, 0)})
+	w.Close()
+	data := buf.Bytes()
+
+	hdr, err := NewReader(bytes.NewReader(data)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hdr.HasModTime() || hdr.ModTime.Unix() != 0 {
+		t.Errorf("expected ModTime to be the epoch but got %v", hdr.ModTime)
+	}
+
+	r := NewReader(bytes.NewReader(data))
+	r.UnsetZeroModTime = true
+	hdr, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.HasModTime() {
+		t.Errorf("expected no ModTime but got %v", hdr.ModTime)
+	}
+}
+
+func TestReaderTruncated(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeBinaryLE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			data := testArchive(t, enc, "hello.txt", "world\n")
+			magic := data[:6]
+			if enc == EncodingTypeBinaryLE {
+				magic = data[:2]
+			}
+			trailer := bytes.LastIndex(data, magic)
+
+			check := func(data []byte, expected error) {
+				r := NewReader(bytes.NewReader(data))
+				_, err := r.Next()
+				if err != nil {
+					t.Fatal("read first header:", err)
+				}
+				_, err = r.Next()
+				if err != expected {
+					t.Errorf("expected %v but got: %v", expected, err)
+				}
+			}
+
+			check(data[:trailer], ErrMissingTrailer)
+			check(data[:trailer+4], io.ErrUnexpectedEOF)
+			check(data[:trailer+20], io.ErrUnexpectedEOF)
+		})
+	}
+}
+
+func TestReaderEntryDigest(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "hello.txt", "world\n", "empty", "")
+	r := NewReader(bytes.NewReader(data))
+	r.Hash = sha256.New
+
+	for _, body := range []string{"world\n", ""} {
+		_, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "" && r.EntryDigest() != nil {
+			t.Error("expected no digest before the data is read")
+		}
+		_, err = ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := sha256.Sum256([]byte(body))
+		if !bytes.Equal(r.EntryDigest(), expected[:]) {
+			t.Errorf("expected digest %x but got %x", expected, r.EntryDigest())
+		}
+	}
+}
+
+func TestReaderAssumeRegular(t *testing.T) {
+	for _, assume := range []bool{false, true} {
+		fd, err := os.Open("test-data/zero-mode.cpio")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer fd.Close()
+
+		r := NewReader(fd)
+		r.AssumeRegular = assume
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (hdr.Mode&^07777 == modeRegular) != assume {
+			t.Errorf("AssumeRegular=%t: unexpected mode %o", assume, hdr.Mode)
+		}
+		intEq(t, "permissions", 0664, int(hdr.Mode&07777))
+	}
+}
+
+func TestReaderRemainder(t *testing.T) {
+	first := testArchive(t, EncodingTypeASCIISVR4, "first.txt", "1")
+	second := testArchive(t, EncodingTypeASCIISVR4, "second.txt", "2")
+	r := NewReader(bytes.NewReader(append(first, second...)))
+
+	if r.Remainder() != nil {
+		t.Error("expected no remainder before the trailer")
+	}
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Error("expected io.EOF to persist after the trailer but got:", err)
+	}
+
+	rest, err := ioutil.ReadAll(r.Remainder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, second) {
+		t.Errorf("expected remainder to be the second archive but got %q", rest)
+	}
+}
+
+func TestReaderEntryOffset(t *testing.T) {
+	r := NewReader(bytes.NewReader(testArchive(t, EncodingTypeASCIISVR4, "a", "hello world", "b", "")))
+	intEq(t, "EntryOffset before Next", 0, int(r.EntryOffset()))
+
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "EntryOffset after Next", 0, int(r.EntryOffset()))
+	buf := make([]byte, 5)
+	io.ReadFull(r, buf)
+	intEq(t, "EntryOffset after partial read", 5, int(r.EntryOffset()))
+	ioutil.ReadAll(r)
+	intEq(t, "EntryOffset after full read", 11, int(r.EntryOffset()))
+
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "EntryOffset for next entry", 0, int(r.EntryOffset()))
+}
+
+func TestReaderODCRDev(t *testing.T) {
+	// /dev/tty1, char device 4, 1, as written by cpio -H odc
+	data := MagicODC + "000000" + "000001" + "020620" + "000000" + "000005" + "000001" +
+		"002001" + "00000002471" + "000005" + "00000000000" + "tty1\x00" +
+		MagicODC + "000000000000000000000000000000000001000000" + "00000000000" + "000013" + "00000000000" + "TRAILER!!!\x00"
+
+	hdr, err := NewReader(bytes.NewReader([]byte(data))).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "RDevMajor", 4, hdr.RDevMajor)
+	intEq(t, "RDevMinor", 1, hdr.RDevMinor)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteHeaderOnly(hdr)
+	w.Close()
+	if buf.String() != data {
+		t.Errorf("expected round trip to match:\nExpected: %q\nActual:   %q", data, buf.String())
+	}
+}
+
+func TestNewReaderAt(t *testing.T) {
+	prefix := []byte("kernel image")
+	archive := testArchive(t, EncodingTypeASCIISVR4, "init", "#!/bin/sh\n")
+	data := append(append(append([]byte{}, prefix...), archive...), archive...)
+
+	r := NewReaderAt(bytes.NewReader(data), int64(len(prefix)))
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "init" {
+		t.Errorf("expected name to be init but got %s", hdr.Name)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Fatal("expected io.EOF but got:", err)
+	}
+
+	// the second segment follows the first
+	hdr, err = NewReader(r.Remainder()).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != "init" {
+		t.Errorf("expected name to be init but got %s", hdr.Name)
+	}
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field string
+		hex   bool
+		value int64
+		ok    bool
+	}{
+		{"000644", false, 0644, true},
+		{"777777", false, 0777777, true},
+		{"000648", false, 0, false},
+		{"", false, 0, false},
+		{"-00001", false, 0, false},
+		{"000081B4", true, 0x81b4, true},
+		{"000081b4", true, 0x81b4, true},
+		{"7FFFFFFFFFFFFFFF", true, 1<<63 - 1, true},
+		{"8000000000000000", true, 0, false},
+		{"0000 1B4", true, 0, false},
+	}
+	for _, test := range tests {
+		parse := ParseOctalField
+		if test.hex {
+			parse = ParseHexField
+		}
+		v, err := parse([]byte(test.field))
+		if test.ok && (err != nil || v != test.value) {
+			t.Errorf("parse %q: expected %d but got %d, %v", test.field, test.value, v, err)
+		}
+		if !test.ok && err != ErrHeader {
+			t.Errorf("parse %q: expected ErrHeader but got %d, %v", test.field, v, err)
+		}
+	}
+
+	b := []byte("000081B4")
+	allocs := testing.AllocsPerRun(100, func() { ParseHexField(b) })
+	if allocs != 0 {
+		t.Errorf("expected no allocations but got %v", allocs)
+	}
+}
+
+func TestReaderUnpaddedNames(t *testing.T) {
+	// newc entries as written by a tool that pads neither names nor data
+	entry := func(name, body string) string {
+		return fmt.Sprintf("%s%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%s\x00%s",
+			MagicNewc, 1, 0100644, 0, 0, 1, 1337, len(body), 0, 0, 0, 0, len(name)+1, 0, name, body)
+	}
+	data := entry("abc.txt", "world\n") + entry("ab", "c") + entry("TRAILER!!!", "")
+
+	_, err := NewReader(bytes.NewReader([]byte(data))).Next()
+	if !errors.Is(err, ErrHeader) || !strings.Contains(err.Error(), "abc.txt") {
+		t.Error("expected ErrHeader naming the entry but got:", err)
+	}
+
+	r := NewReader(bytes.NewReader([]byte(data)))
+	r.UnpaddedNames = true
+	for _, expected := range [][2]string{{"abc.txt", "world\n"}, {"ab", "c"}} {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != expected[0] || string(body) != expected[1] {
+			t.Errorf("expected %s with %q but got %s with %q", expected[0], expected[1], hdr.Name, body)
+		}
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Error("expected io.EOF but got:", err)
+	}
+}
+
+func TestReaderMaxInputSize(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "hello world", "b", "more data")
+
+	r := NewReader(bytes.NewReader(data))
+	r.MaxInputSize = int64(len(data))
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("archive within the limit:", err)
+		}
+	}
+	intEq(t, "InputOffset", len(data), int(r.InputOffset()))
+
+	r = NewReader(bytes.NewReader(data))
+	r.MaxInputSize = HeaderSizeNewc + 4 + 5
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrInputTooLarge {
+		t.Error("expected ErrInputTooLarge from Read but got:", err)
+	}
+	if _, err := r.Next(); err != ErrInputTooLarge {
+		t.Error("expected ErrInputTooLarge from Next but got:", err)
+	}
+}
+
+func TestReaderForEach(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "first", "b", "second", "c", "third")
+
+	var seen []string
+	err := NewReader(bytes.NewReader(data)).ForEach(func(hdr *Header, body io.Reader) error {
+		if hdr.Name == "b" {
+			return SkipEntry
+		}
+		// read only part of the body
+		buf := make([]byte, 3)
+		io.ReadFull(body, buf)
+		seen = append(seen, hdr.Name+":"+string(buf))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(seen, ",") != "a:fir,c:thi" {
+		t.Errorf("expected entries a:fir,c:thi but got %s", strings.Join(seen, ","))
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = NewReader(bytes.NewReader(data)).ForEach(func(hdr *Header, body io.Reader) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Error("expected the callback error but got:", err)
+	}
+	intEq(t, "calls", 1, calls)
+}
+
+func TestReaderTruncatedTrailerPadding(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISVR4, EncodingTypeBinaryLE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			data := testArchive(t, enc, "a", "hello")
+			// the trailer name is 11 bytes, so both formats pad it
+			data = data[:len(data)-1]
+
+			r := NewReader(bytes.NewReader(data))
+			r.Next()
+			if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+				t.Error("expected io.ErrUnexpectedEOF by default but got:", err)
+			}
+
+			r = NewReader(bytes.NewReader(data))
+			r.TruncatedTrailerPadding = true
+			r.Next()
+			if _, err := r.Next(); err != io.EOF {
+				t.Error("expected io.EOF but got:", err)
+			}
+
+			r = NewReader(bytes.NewReader(data[:len(data)-4]))
+			r.TruncatedTrailerPadding = true
+			r.Next()
+			if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+				t.Error("expected io.ErrUnexpectedEOF for a truncated trailer name but got:", err)
+			}
+		})
+	}
+}
+
+func TestReaderNextContent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	add := func(name string, mode int64, inode, nlink int, body string) {
+		w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: mode, Inode: inode, NLink: nlink, Size: int64(len(body)), ModTime: testModTime}, strings.NewReader(body))
+	}
+	add("dir", 040755, 1, 2, "")
+	add("data", 0100644, 2, 2, "shared")
+	add("link", 0100644, 2, 2, "")
+	add("empty", 0100644, 3, 1, "")
+	add("sym", 0120777, 4, 1, "data")
+	add("gen1", 0100644, 0, 2, "")
+	add("gen2", 0100644, 0, 2, "")
+	w.Close()
+
+	r := NewReader(buf)
+	var names []string
+	for {
+		hdr, err := r.NextContent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "dir,data,empty,sym,gen1,gen2" {
+		t.Errorf("expected dir,data,empty,sym,gen1,gen2 but got %s", strings.Join(names, ","))
+	}
+}
+
+func TestReaderSpacePadded(t *testing.T) {
+	data := MagicODC + "    54" + "  2471" + "100664" + "  1750" + "  1750" + "     1" +
+		"      " + "       2471" + "    12" + "          6" + "hello.txt\x00world\n" +
+		MagicODC + "000000000000000000000000000000000001000000" + "00000000000" + "000013" + "00000000000" + "TRAILER!!!\x00"
+
+	if _, err := NewReader(bytes.NewReader([]byte(data))).Next(); err != ErrHeader {
+		t.Error("expected ErrHeader by default but got:", err)
+	}
+
+	r := NewReader(bytes.NewReader([]byte(data)))
+	r.SpacePadded = true
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "DevMinor", 44, hdr.DevMinor)
+	intEq(t, "Inode", 1337, hdr.Inode)
+	intEq(t, "UID", 1000, hdr.UID)
+	intEq(t, "RDevMinor", 0, hdr.RDevMinor)
+	intEq(t, "Size", 6, int(hdr.Size))
+	if hdr.Name != "hello.txt" {
+		t.Errorf("expected name to be hello.txt but got %s", hdr.Name)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Error("expected io.EOF but got:", err)
+	}
+}
+
+func TestReaderSawTrailer(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "hello")
+
+	r := NewReader(bytes.NewReader(data))
+	for {
+		_, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.SawTrailer() {
+			t.Error("expected SawTrailer to be false before the trailer")
+		}
+	}
+	if !r.SawTrailer() {
+		t.Error("expected SawTrailer to be true after the trailer")
+	}
+
+	// cut the archive off where the trailer begins
+	end := bytes.LastIndex(data, []byte(MagicNewc))
+	r = NewReader(bytes.NewReader(data[:end]))
+	r.Next()
+	if _, err := r.Next(); err != ErrMissingTrailer {
+		t.Error("expected ErrMissingTrailer but got:", err)
+	}
+	if r.SawTrailer() {
+		t.Error("expected SawTrailer to be false for a truncated archive")
+	}
+}
+
+func TestReaderBinaryPadding(t *testing.T) {
+	// names "ab" and "abc" have odd and even namesize including the NUL
+	nameBody := []string{"ab", "xy", "ab", "xyz", "abc", "xy", "abc", "xyz", "ab", ""}
+	for _, enc := range []EncodingType{EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			data := testArchive(t, enc, nameBody...)
+			if len(data)%2 != 0 {
+				t.Errorf("expected an even archive length but got %d", len(data))
+			}
+			r := NewReader(bytes.NewReader(data))
+			for i := 0; i < len(nameBody); i += 2 {
+				hdr, err := r.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := ioutil.ReadAll(r)
+				if hdr.Name != nameBody[i] || string(body) != nameBody[i+1] {
+					t.Errorf("expected %s with %q but got %s with %q", nameBody[i], nameBody[i+1], hdr.Name, body)
+				}
+			}
+			if _, err := r.Next(); err != io.EOF {
+				t.Error("expected io.EOF but got:", err)
+			}
+		})
+	}
+}
+
+// readCounter counts the bytes read from a seekable reader
+type readCounter struct {
+	*bytes.Reader
+	n int
+}
+
+func (r *readCounter) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += n
+	return n, err
+}
+
+func TestReaderHeaders(t *testing.T) {
+	big := strings.Repeat("x", 1<<20)
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", big, "b", "small", "c", big)
+
+	rc := &readCounter{Reader: bytes.NewReader(data)}
+	hdrs, err := NewReader(rc).Headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "headers", 3, len(hdrs))
+	for i, name := range []string{"a", "b", "c"} {
+		if hdrs[i].Name != name {
+			t.Errorf("expected header %d to be %s but got %s", i, name, hdrs[i].Name)
+		}
+	}
+	if rc.n >= len(big) {
+		t.Errorf("expected data to be skipped by seeking but %d bytes were read", rc.n)
+	}
+
+	// without seeking, the data is read and discarded
+	hdrs, err = NewReader(io.MultiReader(bytes.NewReader(data))).Headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "headers without seeking", 3, len(hdrs))
+
+	// data cut short is still reported when skipped by seeking
+	_, err = NewReader(bytes.NewReader(data[:len(data)/2])).Headers()
+	if err != io.ErrUnexpectedEOF {
+		t.Error("expected io.ErrUnexpectedEOF but got:", err)
+	}
+}
+
+func TestReaderReadTimeout(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "hello")
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		// stall partway through the body
+		server.Write(data[:HeaderSizeNewc+4+2])
+	}()
+
+	r := NewReader(client)
+	r.ReadTimeout = 50 * time.Millisecond
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrReadTimeout {
+		t.Error("expected ErrReadTimeout but got:", err)
+	}
+}
+
+func TestReaderBadMagic(t *testing.T) {
+	tests := []struct {
+		data, magic string
+	}{
+		{"PK\x03\x04", "magic 0x50 0x4b"},
+		{"070709" + strings.Repeat("0", 100), "magic 0x30 0x37 0x30 0x37 0x30 0x39"},
+	}
+	for _, test := range tests {
+		_, err := NewReader(strings.NewReader(test.data)).Next()
+		if !errors.Is(err, ErrHeader) || !strings.HasSuffix(err.Error(), test.magic) {
+			t.Errorf("expected ErrHeader with %s but got: %v", test.magic, err)
+		}
+	}
+}
+
+func TestReaderWideODC(t *testing.T) {
+	// an odc archive written with eight digits for each six-digit field
+	wide := func(name, body string) string {
+		return fmt.Sprintf("070707%08o%08o%08o%08o%08o%08o%08o%011o%08o%011o%s\x00%s",
+			0, 7, 0100644, 1000, 1000, 1, 0, 1337, len(name)+1, len(body), name, body)
+	}
+	data := wide("hello.txt", "hi there") + wide("TRAILER!!!", "")
+
+	cr := NewReader(strings.NewReader(data))
+	hdr, err := cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if hdr.Name != "hello.txt" {
+		t.Errorf("expected name to be hello.txt but got %q", hdr.Name)
+	}
+	intEq(t, "Inode", 7, hdr.Inode)
+	intEq(t, "UID", 1000, hdr.UID)
+	intEq(t, "Mode", 0100644, int(hdr.Mode))
+	body, err := ioutil.ReadAll(cr)
+	if err != nil || string(body) != "hi there" {
+		t.Errorf("expected body to be \"hi there\" but got %q: %v", body, err)
+	}
+	_, err = cr.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF but got: %v", err)
+	}
+
+	// a name that lines up with neither layout is read as is, or reported
+	// in strict mode
+	bad := "070707" + strings.Repeat("0", 53) + "000005" + strings.Repeat("0", 11) + "abcde"
+	hdr, err = NewReader(strings.NewReader(bad)).Next()
+	if err != nil || hdr.Name != "abcde" {
+		t.Errorf("expected the name abcde but got %v: %v", hdr, err)
+	}
+	cr = NewReader(strings.NewReader(bad))
+	cr.Strict = true
+	_, err = cr.Next()
+	if !errors.Is(err, ErrHeader) || !strings.Contains(err.Error(), "NUL-terminated") {
+		t.Errorf("expected ErrHeader about the name but got: %v", err)
+	}
+}
+
+func TestReaderNextArchive(t *testing.T) {
+	var input []byte
+	input = append(input, testArchive(t, EncodingTypeASCIISVR4, "a", "first")...)
+	input = append(input, make([]byte, 512)...)
+	input = append(input, testArchive(t, EncodingTypeASCIISUSv2, "b", "second")...)
+	input = append(input, "GARBAGE\n"...)
+	input = append(input, testArchive(t, EncodingTypeBinaryLE, "c", "third")...)
+	input = append(input, make([]byte, 100)...)
+
+	r := NewReader(bytes.NewReader(input))
+	for i, expected := range []struct {
+		name    string
+		skipped int
+	}{{"a", 0}, {"b", 512}, {"c", 8}} {
+		if i > 0 {
+			skipped, err := r.NextArchive()
+			if err != nil {
+				t.Fatal(err)
+			}
+			intEq(t, "skipped", expected.skipped, int(skipped))
+		}
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != expected.name {
+			t.Errorf("expected name to be %s but got %s", expected.name, hdr.Name)
+		}
+		if _, err = r.Next(); err != io.EOF {
+			t.Fatal("expected io.EOF but got:", err)
+		}
+	}
+	skipped, err := r.NextArchive()
+	if err != io.EOF {
+		t.Error("expected io.EOF but got:", err)
+	}
+	intEq(t, "skipped", 100, int(skipped))
+
+	input = append(testArchive(t, EncodingTypeASCIISVR4, "a", "first"), "trailing junk"...)
+	r = NewReader(bytes.NewReader(input))
+	if _, err = r.NextArchive(); err == nil {
+		t.Error("expected an error before the trailer")
+	}
+	r.Next()
+	r.Next()
+	if _, err = r.NextArchive(); !errors.Is(err, ErrNoArchive) {
+		t.Error("expected ErrNoArchive but got:", err)
+	}
+}
+
+func TestReaderCopyBody(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a.txt", "hello")
+
+	cr := NewReader(bytes.NewReader(data))
+	_, err := cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	var buf bytes.Buffer
+	n, err := cr.CopyBody(&buf)
+	if err != nil {
+		t.Errorf("expected no error but got: %v", err)
+	}
+	intEq(t, "copied bytes", 5, int(n))
+	if buf.String() != "hello" {
+		t.Errorf("expected body to be hello but got %q", buf.String())
+	}
+	_, err = cr.Next()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF but got: %v", err)
+	}
+
+	// cut the archive off partway through the body
+	cr = NewReader(bytes.NewReader(data[:HeaderSizeNewc+6+3]))
+	_, err = cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	n, err = cr.CopyBody(ioutil.Discard)
+	if !errors.Is(err, ErrTruncatedBody) {
+		t.Errorf("expected ErrTruncatedBody but got: %v", err)
+	}
+	intEq(t, "copied bytes", 3, int(n))
+	_, err = cr.Next()
+	if !errors.Is(err, ErrTruncatedBody) {
+		t.Errorf("expected ErrTruncatedBody from Next but got: %v", err)
+	}
+}
+
+func TestReaderSkipBufferSize(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", body, "b", "after")
+
+	// hide the bytes.Reader's Seek so the data is read through
+	cr := NewReader(struct{ io.Reader }{bytes.NewReader(data)})
+	cr.SkipBufferSize = 7
+	_, err := cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	hdr, err := cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if hdr.Name != "b" {
+		t.Errorf("expected name to be b but got %q", hdr.Name)
+	}
+	intEq(t, "skip buffer", 7, len(cr.skipBuf))
+}
+
+func TestReaderNextN(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "1", "b", "22", "c", "333")
+
+	cr := NewReader(bytes.NewReader(data))
+	hdr, err := cr.NextN(2)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if hdr.Name != "b" {
+		t.Errorf("expected name to be b but got %q", hdr.Name)
+	}
+	hdr, err = cr.NextN(1)
+	if err != nil || hdr.Name != "c" {
+		t.Errorf("expected c but got %v: %v", hdr, err)
+	}
+	body, _ := ioutil.ReadAll(cr)
+	if string(body) != "333" {
+		t.Errorf("expected body to be 333 but got %q", body)
+	}
+
+	_, err = NewReader(bytes.NewReader(data)).NextN(4)
+	if err != io.EOF {
+		t.Errorf("expected io.EOF past the last entry but got: %v", err)
+	}
+	_, err = NewReader(bytes.NewReader(data)).NextN(0)
+	if err == nil {
+		t.Error("expected an error for n = 0")
+	}
+}
+
+func TestReaderOpen(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "first", "b", "second")
+
+	cr := NewReader(struct{ io.Reader }{bytes.NewReader(data)})
+	body, hdr, err := cr.Open()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if hdr.Name != "a" {
+		t.Errorf("expected name to be a but got %q", hdr.Name)
+	}
+	p := make([]byte, 2)
+	io.ReadFull(body, p)
+	if err = body.Close(); err != nil {
+		t.Errorf("expected no error from Close but got: %v", err)
+	}
+	if _, err = body.Read(p); err == nil {
+		t.Error("expected an error reading a closed body")
+	}
+
+	body, hdr, err = cr.Open()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	data, err = ioutil.ReadAll(body)
+	if err != nil || string(data) != "second" || hdr.Name != "b" {
+		t.Errorf("expected b to hold second but got %q: %v", data, err)
+	}
+	body.Close()
+
+	_, _, err = cr.Open()
+	if err != io.EOF {
+		t.Errorf("expected io.EOF but got: %v", err)
+	}
+}
+
+func TestReaderMaxReadChunk(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", strings.Repeat("x", 100))
+
+	cr := NewReader(bytes.NewReader(data))
+	cr.MaxReadChunk = 30
+	_, err := cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	p := make([]byte, 1000)
+	total := 0
+	for {
+		n, err := cr.Read(p)
+		if n > 30 {
+			t.Errorf("expected at most 30 bytes per read but got %d", n)
+		}
+		total += n
+		if err != nil {
+			break
+		}
+	}
+	intEq(t, "total bytes", 100, total)
+}
+
+func TestReaderNameSizeOverflow(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a", "body")
+	// namesize is the 12th field after the magic
+	copy(data[6+11*8:], "FFFFFFF0")
+
+	_, err := NewReader(bytes.NewReader(data)).Next()
+	if !errors.Is(err, ErrHeader) {
+		t.Errorf("expected ErrHeader but got: %v", err)
+	}
+}
+
+func TestReaderTruncatedBody(t *testing.T) {
+	data := testArchive(t, EncodingTypeASCIISVR4, "a.txt", "hello")
+
+	// hide the bytes.Reader's Seek so the data is read through
+	cr := NewReader(struct{ io.Reader }{bytes.NewReader(data[:HeaderSizeNewc+6+3])})
+	_, err := cr.Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	body, err := ioutil.ReadAll(cr)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF but got: %v", err)
+	}
+	if string(body) != "hel" {
+		t.Errorf("expected body to be hel but got %q", body)
+	}
+	_, err = cr.Next()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF from Next but got: %v", err)
+	}
+
+	// skipping the data finds the truncation too
+	cr = NewReader(struct{ io.Reader }{bytes.NewReader(data[:HeaderSizeNewc+6+3])})
+	cr.Next()
+	_, err = cr.Next()
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF from skipping but got: %v", err)
+	}
+}
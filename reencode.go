@@ -0,0 +1,36 @@
+package cpio
+
+import "io"
+
+// Reencode reads the archive from src and writes its entries to dst in the
+// target encoding, followed by a trailer.
+//
+// Every field is carried over, with device numbers combined or split as
+// the target requires. ErrFieldOverflow is returned for any field the
+// target cannot hold, such as minor numbers above 255 in odc and binary
+// archives, or inodes above 65535 in binary archives. For
+// EncodingTypeASCIISVR4CRC checksums are computed from the data as it is
+// copied; for other targets they are dropped.
+func Reencode(dst io.Writer, src io.Reader, target EncodingType) error {
+	cr := NewReader(src)
+	cw := NewWriterEncoding(dst, target)
+	cw.ComputeChecksum = target == EncodingTypeASCIISVR4CRC
+	cw.KeepZeroNLink = true
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		h := *hdr
+		h.Encoding = target
+		h.Checksum = 0
+		err = cw.AddReader(&h, cr)
+		if err != nil {
+			return err
+		}
+	}
+	return cw.Close()
+}
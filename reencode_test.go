@@ -0,0 +1,99 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReencode(t *testing.T) {
+	golden, err := ioutil.ReadFile("test-data/newc-multi.cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renumber the inodes bsdcpio copied from disk to fit every encoding
+	in := new(bytes.Buffer)
+	w := NewWriter(in)
+	r := NewReader(bytes.NewReader(golden))
+	for i := 1; ; i++ {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr.Inode = i
+		if err = w.AddReader(hdr, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+	src := in.Bytes()
+
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			if err := Reencode(buf, bytes.NewReader(src), enc); err != nil {
+				t.Fatal(err)
+			}
+
+			r := NewReader(bytes.NewReader(buf.Bytes()))
+			for {
+				hdr, err := r.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Encoding != enc {
+					t.Errorf("%s: expected encoding to be %v but got %v", hdr.Name, enc, hdr.Encoding)
+				}
+				data, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := uint32(0)
+				if enc == EncodingTypeASCIISVR4CRC {
+					expected = Checksum(data)
+				}
+				if hdr.Checksum != expected {
+					t.Errorf("%s: expected checksum to be %d but got %d", hdr.Name, expected, hdr.Checksum)
+				}
+			}
+
+			ok, diffs, err := EquivalentContent(bytes.NewReader(src), bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("expected reencoded archive to be equivalent but got %q", diffs)
+			}
+		})
+	}
+
+	buf := new(bytes.Buffer)
+	w = NewWriter(buf)
+	dev := NewCharDeviceHeader("dev/tty", 4, 1000, 0620)
+	dev.Encoding = EncodingTypeASCIISVR4
+	w.WriteHeaderOnly(dev)
+	w.Close()
+	if err := Reencode(ioutil.Discard, buf, EncodingTypeASCIISUSv2); !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+
+	// distinct inodes must not be truncated into apparent hard links
+	buf.Reset()
+	w = NewWriter(buf)
+	for _, ino := range []int{70000, 4464} {
+		w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: fmt.Sprint(ino), Mode: 0100644, NLink: 1, Inode: ino}, bytes.NewReader(nil))
+	}
+	w.Close()
+	if err := Reencode(ioutil.Discard, buf, EncodingTypeBinaryLE); !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow for inode 70000 but got:", err)
+	}
+}
@@ -0,0 +1,83 @@
+package cpio
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrFileContextsFormat is returned by ParseFileContexts for malformed
+// file_contexts data.
+var ErrFileContextsFormat = errors.New("cpio: malformed file_contexts entry")
+
+// securityContextNames are the base names under which SELinux file contexts
+// are conventionally stored, by policy trees under etc/selinux and at the
+// root of Android ramdisks. Compiled file_contexts.bin files are not
+// included, as they can't be parsed as text.
+var securityContextNames = map[string]bool{
+	"file_contexts":            true,
+	"file_contexts.local":      true,
+	"file_contexts.homedirs":   true,
+	"plat_file_contexts":       true,
+	"nonplat_file_contexts":    true,
+	"vendor_file_contexts":     true,
+	"system_ext_file_contexts": true,
+	"product_file_contexts":    true,
+	"odm_file_contexts":        true,
+}
+
+// IsSecurityContextEntry reports whether hdr is a regular file holding
+// SELinux file contexts in the text format read by ParseFileContexts.
+// Such entries are ordinary files and are read and written like any other.
+func IsSecurityContextEntry(hdr *Header) bool {
+	if hdr.Mode&^07777 != modeRegular {
+		return false
+	}
+	return securityContextNames[path.Base(hdr.Name)]
+}
+
+// FileContext is a single line of an SELinux file_contexts file, labeling
+// the paths matched by Pattern.
+type FileContext struct {
+	Pattern  string // regular expression matched against full paths
+	FileType string // file type restriction such as "--" or "-d", or empty for any
+	Context  string // security context, such as "system_u:object_r:bin_t:s0", or "<<none>>"
+}
+
+// ParseFileContexts parses the data of an entry recognized by
+// IsSecurityContextEntry. Blank lines and comments starting with '#' are
+// skipped. Each remaining line must hold a pattern, an optional file type
+// and a context, separated by whitespace.
+func ParseFileContexts(r io.Reader) ([]FileContext, error) {
+	var contexts []FileContext
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		var fc FileContext
+		switch len(fields) {
+		case 2:
+			fc = FileContext{Pattern: fields[0], Context: fields[1]}
+		case 3:
+			fc = FileContext{Pattern: fields[0], FileType: fields[1], Context: fields[2]}
+			switch fc.FileType {
+			case "--", "-d", "-c", "-b", "-s", "-l", "-p":
+			default:
+				return nil, fmt.Errorf("%w: line %d: unknown file type %q", ErrFileContextsFormat, line, fc.FileType)
+			}
+		default:
+			return nil, fmt.Errorf("%w: line %d: expected 2 or 3 fields but found %d", ErrFileContextsFormat, line, len(fields))
+		}
+		contexts = append(contexts, fc)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return contexts, nil
+}
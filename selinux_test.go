@@ -0,0 +1,65 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSecurityContexts(t *testing.T) {
+	fc := "# generated\n\n/bin(/.*)?\t\tsystem_u:object_r:bin_t:s0\n/dev/null -c system_u:object_r:null_device_t:s0\n/tmp/.* <<none>>\n"
+	data := testArchive(t, EncodingTypeASCIISVR4,
+		"etc/selinux/targeted/contexts/files/file_contexts", fc,
+		"file_contexts.bin", "binary",
+		"etc/hostname", "box\n",
+	)
+
+	r := NewReader(bytes.NewReader(data))
+	var found []string
+	var contexts []FileContext
+	for {
+		hdr, err := r.Next()
+		if err != nil {
+			break
+		}
+		if !IsSecurityContextEntry(hdr) {
+			continue
+		}
+		found = append(found, hdr.Name)
+		contexts, err = ParseFileContexts(r)
+		if err != nil {
+			t.Fatalf("expected no error but got: %v", err)
+		}
+	}
+	if len(found) != 1 || found[0] != "etc/selinux/targeted/contexts/files/file_contexts" {
+		t.Fatalf("expected only the file_contexts entry to be found but got %q", found)
+	}
+
+	expected := []FileContext{
+		{Pattern: "/bin(/.*)?", Context: "system_u:object_r:bin_t:s0"},
+		{Pattern: "/dev/null", FileType: "-c", Context: "system_u:object_r:null_device_t:s0"},
+		{Pattern: "/tmp/.*", Context: "<<none>>"},
+	}
+	intEq(t, "contexts", len(expected), len(contexts))
+	for i := range expected {
+		if i < len(contexts) && contexts[i] != expected[i] {
+			t.Errorf("expected context %d to be %+v but got %+v", i, expected[i], contexts[i])
+		}
+	}
+
+	if IsSecurityContextEntry(&Header{Name: "file_contexts", Mode: 040755}) {
+		t.Error("expected a directory not to be a security context entry")
+	}
+
+	for _, bad := range []string{"/bin\n", "/bin -x system_u:object_r:bin_t:s0\n", "/bin -- a b\n"} {
+		_, err := ParseFileContexts(strings.NewReader(bad))
+		if !errors.Is(err, ErrFileContextsFormat) {
+			t.Errorf("expected ErrFileContextsFormat for %q but got: %v", bad, err)
+		}
+	}
+	_, err := ParseFileContexts(strings.NewReader(""))
+	if err != nil {
+		t.Errorf("expected no error for empty data but got: %v", err)
+	}
+}
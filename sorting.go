@@ -0,0 +1,112 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A SortingWriter collects entries in any order and writes them as an
+// archive in a canonical order on Close: each directory before its
+// contents, and otherwise sorted by name one path element at a time, so the
+// output is extractable and deterministic however the entries were added.
+//
+// Every entry's data is held in memory until Close, so memory use grows
+// with the total size of the archive.
+type SortingWriter struct {
+	w       *Writer
+	enc     EncodingType
+	entries []*sortEntry
+	cur     *sortEntry
+	closed  bool
+}
+
+type sortEntry struct {
+	hdr  Header
+	path []string
+	data bytes.Buffer
+}
+
+// NewSortingWriter creates a new SortingWriter writing to w. Every entry is
+// written in enc, whatever the Encoding of its header.
+func NewSortingWriter(w io.Writer, enc EncodingType) *SortingWriter {
+	return &SortingWriter{w: NewWriterEncoding(w, enc), enc: enc}
+}
+
+// WriteHeader begins a new entry for hdr, whose data is then supplied with
+// Write. ErrWriteTooShort is returned if the previous entry is missing data.
+func (sw *SortingWriter) WriteHeader(hdr *Header) error {
+	if sw.closed {
+		return ErrWriteAfterClose
+	}
+	if err := sw.finishEntry(); err != nil {
+		return err
+	}
+	e := &sortEntry{hdr: *hdr}
+	e.hdr.Encoding = sw.enc
+	e.path = strings.Split(strings.Trim(e.hdr.CleanName(), "/"), "/")
+	sw.entries = append(sw.entries, e)
+	sw.cur = e
+	return nil
+}
+
+// Write adds to the data of the current entry, returning ErrWriteTooLong
+// if more than its Size bytes are written.
+func (sw *SortingWriter) Write(b []byte) (int, error) {
+	if sw.closed {
+		return 0, ErrWriteAfterClose
+	}
+	if sw.cur == nil {
+		return 0, ErrWriteTooLong
+	}
+	rem := sw.cur.hdr.Size - int64(sw.cur.data.Len())
+	if int64(len(b)) > rem {
+		n, _ := sw.cur.data.Write(b[:rem])
+		return n, ErrWriteTooLong
+	}
+	return sw.cur.data.Write(b)
+}
+
+// Close sorts the entries and writes them, followed by the trailer.
+func (sw *SortingWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	if err := sw.finishEntry(); err != nil {
+		return err
+	}
+	sw.closed = true
+
+	sort.SliceStable(sw.entries, func(i, j int) bool {
+		return lessPath(sw.entries[i].path, sw.entries[j].path)
+	})
+	for _, e := range sw.entries {
+		err := sw.w.AddReader(&e.hdr, &e.data)
+		if err != nil {
+			return err
+		}
+	}
+	sw.entries = nil
+	return sw.w.Close()
+}
+
+// finishEntry checks that the current entry has all of its data
+func (sw *SortingWriter) finishEntry() error {
+	if sw.cur != nil && int64(sw.cur.data.Len()) < sw.cur.hdr.Size {
+		return ErrWriteTooShort
+	}
+	sw.cur = nil
+	return nil
+}
+
+// lessPath orders paths element by element, so a directory sorts directly
+// before its contents
+func lessPath(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
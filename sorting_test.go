@@ -0,0 +1,61 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSortingWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	sw := NewSortingWriter(buf, EncodingTypeASCIISVR4)
+	add := func(name string, mode int64, body string) {
+		err := sw.WriteHeader(&Header{Name: name, Mode: mode, NLink: 1, Size: int64(len(body)), ModTime: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(sw, body)
+	}
+	add("a/b/file", 0100644, "deep")
+	add("a-b", 0100644, "dash")
+	add("a/", 040755, "")
+	add("a/b", 040755, "")
+	add("z", 0100644, "last")
+	add("a/c", 0100644, "c")
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	r := NewReader(buf)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Encoding != EncodingTypeASCIISVR4 {
+			t.Errorf("expected %s to be newc but got %s", hdr.Name, hdr.Encoding)
+		}
+		body, _ := ioutil.ReadAll(r)
+		names = append(names, hdr.Name+":"+string(body))
+	}
+	expected := "a/:,a/b:,a/b/file:deep,a/c:c,a-b:dash,z:last"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("expected %s but got %s", expected, strings.Join(names, ","))
+	}
+
+	sw = NewSortingWriter(ioutil.Discard, EncodingTypeASCIISVR4)
+	sw.WriteHeader(&Header{Name: "short", Mode: 0100644, Size: 4})
+	if _, err := io.WriteString(sw, "toolong"); err != ErrWriteTooLong {
+		t.Error("expected ErrWriteTooLong but got:", err)
+	}
+	sw.WriteHeader(&Header{Name: "short", Mode: 0100644, Size: 4})
+	if err := sw.Close(); err != ErrWriteTooShort {
+		t.Error("expected ErrWriteTooShort but got:", err)
+	}
+}
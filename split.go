@@ -0,0 +1,77 @@
+package cpio
+
+import "io"
+
+// A SplitWriter writes an archive across multiple volumes, each of which is
+// a complete archive with its own trailer, such as for a set of fixed-size
+// tapes or files.
+//
+// Entries are never split across volumes. A new volume is started before an
+// entry that would take the current one, including its trailer, past the
+// size limit. An entry too large to fit in an empty volume is written alone
+// in a volume that exceeds the limit.
+type SplitWriter struct {
+	next func(volume int) (io.WriteCloser, error)
+	max  int64
+
+	volume int
+	wc     io.WriteCloser
+	cur    *Writer
+}
+
+// NewSplitWriter creates a new SplitWriter calling next to open each volume,
+// numbered from 0, and starting a new volume rather than exceed maxBytes.
+func NewSplitWriter(next func(volume int) (io.WriteCloser, error), maxBytes int64) *SplitWriter {
+	return &SplitWriter{next: next, max: maxBytes}
+}
+
+// WriteHeader writes hdr and prepares to accept the file's contents, first
+// closing the current volume and opening the next if the entry does not fit.
+func (sw *SplitWriter) WriteHeader(hdr *Header) error {
+	size := headerSize(hdr.Encoding, len(hdr.Name)+1) + hdr.Size + dataPad(hdr.Encoding, hdr.Size)
+	trailer := headerSize(hdr.Encoding, len("TRAILER!!!")+1)
+	if sw.cur != nil && sw.cur.w.n > 0 && sw.cur.w.n+sw.cur.pad+size+trailer > sw.max {
+		err := sw.closeVolume()
+		if err != nil {
+			return err
+		}
+	}
+	if sw.cur == nil {
+		wc, err := sw.next(sw.volume)
+		if err != nil {
+			return err
+		}
+		sw.volume++
+		sw.wc = wc
+		sw.cur = NewWriter(wc)
+	}
+	return sw.cur.WriteHeader(hdr)
+}
+
+// Write writes to the current entry in the current volume.
+func (sw *SplitWriter) Write(b []byte) (int, error) {
+	if sw.cur == nil {
+		return 0, ErrWriteTooLong
+	}
+	return sw.cur.Write(b)
+}
+
+// Close finishes the current volume, writing its trailer, and closes it.
+// No volume is opened if no entries were written.
+func (sw *SplitWriter) Close() error {
+	if sw.cur == nil {
+		return nil
+	}
+	return sw.closeVolume()
+}
+
+func (sw *SplitWriter) closeVolume() error {
+	err := sw.cur.Close()
+	cerr := sw.wc.Close()
+	sw.cur = nil
+	sw.wc = nil
+	if err != nil {
+		return err
+	}
+	return cerr
+}
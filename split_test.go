@@ -0,0 +1,63 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+type nopCloseBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *nopCloseBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSplitWriter(t *testing.T) {
+	var volumes []*nopCloseBuffer
+	sw := NewSplitWriter(func(volume int) (io.WriteCloser, error) {
+		intEq(t, "volume", len(volumes), volume)
+		b := new(nopCloseBuffer)
+		volumes = append(volumes, b)
+		return b, nil
+	}, 512)
+
+	bodies := []string{strings.Repeat("a", 80), strings.Repeat("b", 80), strings.Repeat("c", 80), strings.Repeat("d", 1000), "e"}
+	for i, body := range bodies {
+		err := sw.WriteHeader(&Header{Encoding: EncodingTypeASCIISVR4, Name: string(rune('a' + i)), Mode: 0100644, NLink: 1, Size: int64(len(body)), ModTime: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(sw, body)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// each volume holds whole entries: a and b, c, the oversized d, then e
+	expected := []string{"ab", "c", "d", "e"}
+	intEq(t, "volumes", len(expected), len(volumes))
+	for i, vol := range volumes {
+		if !vol.closed {
+			t.Errorf("expected volume %d to be closed", i)
+		}
+		if vol.Len() > 512 && i != 2 {
+			t.Errorf("expected volume %d to fit in 512 bytes but got %d", i, vol.Len())
+		}
+		var names string
+		err := NewReader(&vol.Buffer).ForEach(func(hdr *Header, body io.Reader) error {
+			names += hdr.Name
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("volume %d: %v", i, err)
+		}
+		if i < len(expected) && names != expected[i] {
+			t.Errorf("expected volume %d to hold %s but got %s", i, expected[i], names)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package cpio
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// statHeader fills in the owner and device number of h from the system's
+// file information, which os.FileInfo does not expose portably
+func statHeader(h *Header, fi os.FileInfo) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	h.UID = int(st.Uid)
+	h.GID = int(st.Gid)
+	if h.Mode&^07777 == modeCharDev || h.Mode&^07777 == modeBlkDev {
+		h.RDevMajor, h.RDevMinor = splitdev(uint64(st.Rdev))
+	}
+}
+
+// splitdev decodes a device number encoded by mkdev
+func splitdev(dev uint64) (major, minor int) {
+	if runtime.GOOS == "darwin" {
+		return int(dev >> 24 & 0xff), int(dev & 0xffffff)
+	}
+	return int(dev>>8&0xfff | dev>>32&^0xfff), int(dev&0xff | dev>>12&^0xff)
+}
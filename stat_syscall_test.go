@@ -0,0 +1,13 @@
+//go:build linux || darwin
+
+package cpio
+
+import "testing"
+
+func TestSplitdev(t *testing.T) {
+	for _, dev := range [][2]int{{8, 0}, {4, 1}, {259, 65536}, {0, 255}} {
+		major, minor := splitdev(uint64(mkdev(dev[0], dev[1])))
+		intEq(t, "major", dev[0], major)
+		intEq(t, "minor", dev[1], minor)
+	}
+}
@@ -0,0 +1,34 @@
+package cpio
+
+import "math"
+
+// EntryStats holds statistics about the data of an entry, computed by a
+// Reader with Stats set.
+type EntryStats struct {
+	Bytes     int64      // number of bytes read
+	Histogram [256]int64 // number of times each byte value was read
+}
+
+func (s *EntryStats) add(b []byte) {
+	s.Bytes += int64(len(b))
+	for _, c := range b {
+		s.Histogram[c]++
+	}
+}
+
+// Entropy returns the Shannon entropy of the bytes read, from 0 to 8 bits
+// per byte. Data that is already compressed or encrypted is close to 8,
+// and is unlikely to compress further.
+func (s EntryStats) Entropy() float64 {
+	if s.Bytes == 0 {
+		return 0
+	}
+	var e float64
+	for _, n := range s.Histogram {
+		if n > 0 {
+			p := float64(n) / float64(s.Bytes)
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
@@ -0,0 +1,36 @@
+package cpio
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestReaderEntryStats(t *testing.T) {
+	random := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(random)
+	data := testArchive(t, EncodingTypeASCIISVR4, "text", strings.Repeat("a", 1000), "random", string(random))
+
+	r := NewReader(bytes.NewReader(data))
+	r.Stats = true
+
+	r.Next()
+	ioutil.ReadAll(r)
+	stats := r.EntryStats()
+	intEq(t, "Bytes", 1000, int(stats.Bytes))
+	intEq(t, "Histogram['a']", 1000, int(stats.Histogram['a']))
+	if e := stats.Entropy(); e != 0 {
+		t.Errorf("expected entropy of repeated bytes to be 0 but got %f", e)
+	}
+
+	r.Next()
+	if stats = r.EntryStats(); stats.Bytes != 0 {
+		t.Errorf("expected stats to reset for the next entry but got %d bytes", stats.Bytes)
+	}
+	ioutil.ReadAll(r)
+	if e := r.EntryStats().Entropy(); e < 7.9 {
+		t.Errorf("expected entropy of random bytes to be near 8 but got %f", e)
+	}
+}
@@ -0,0 +1,71 @@
+package cpio
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// textCheckSize is how much of the input LooksTextCorrupted examines
+const textCheckSize = 8192
+
+// LooksTextCorrupted reports whether the start of the archive read from r
+// appears to have had each LF byte expanded to CR LF, as by an FTP transfer
+// in text mode. Such archives usually fail with ErrHeader at the first entry
+// whose header or data contained a LF.
+//
+// It checks whether the headers in the first 8KiB are invalid as read but
+// valid once each CR LF is collapsed back to LF, so a false result does not
+// mean the rest of the archive is intact.
+//
+// If r is a *PeekReader or *bufio.Reader, the data is examined with Peek so
+// that nothing is consumed; a *bufio.Reader is only checked up to its buffer
+// size. Otherwise, if r is an io.Seeker it is returned to its starting
+// position, and if not, the bytes examined are consumed.
+func LooksTextCorrupted(r io.Reader) (bool, error) {
+	var b []byte
+	var err error
+	if pr, ok := r.(*PeekReader); ok {
+		b, err = pr.Peek(textCheckSize)
+	} else if br, ok := r.(*bufio.Reader); ok {
+		n := textCheckSize
+		if br.Size() < n {
+			n = br.Size()
+		}
+		b, err = br.Peek(n)
+	} else {
+		b = make([]byte, textCheckSize)
+		var n int
+		n, err = io.ReadFull(r, b)
+		b = b[:n]
+		if s, ok := r.(io.Seeker); ok && n > 0 {
+			if _, serr := s.Seek(int64(-n), io.SeekCurrent); serr != nil {
+				return false, serr
+			}
+		}
+	}
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	if !bytes.Contains(b, []byte("\r\n")) || headersValid(b) {
+		return false, nil
+	}
+	return headersValid(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))), nil
+}
+
+// headersValid reports whether b holds valid headers up to the trailer or
+// the end of b, which may cut off the last entry
+func headersValid(b []byte) bool {
+	r := NewReader(bytes.NewReader(b))
+	for {
+		_, err := r.Next()
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF, ErrMissingTrailer:
+			return true
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cpio
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestLooksTextCorrupted(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			data := testArchive(t, enc, "a.txt", "line one\nline two\n", "b", "x")
+			corrupt := bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+
+			ok, err := LooksTextCorrupted(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				t.Error("expected intact archive not to look corrupted")
+			}
+
+			r := bytes.NewReader(corrupt)
+			ok, err = LooksTextCorrupted(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Error("expected expanded archive to look corrupted")
+			}
+			intEq(t, "Len", len(corrupt), r.Len())
+
+			br := bufio.NewReader(bytes.NewReader(corrupt))
+			ok, err = LooksTextCorrupted(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Error("expected expanded archive to look corrupted")
+			}
+			intEq(t, "Buffered", len(corrupt), br.Buffered())
+
+			pr := NewPeekReader(bytes.NewReader(corrupt))
+			ok, err = LooksTextCorrupted(pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Error("expected expanded archive to look corrupted")
+			}
+			intEq(t, "Buffered", len(corrupt), pr.Buffered())
+		})
+	}
+
+	// an archive of a text file with CRLF line endings is fine
+	data := testArchive(t, EncodingTypeASCIISVR4, "dos.txt", "line one\r\nline two\r\n")
+	ok, err := LooksTextCorrupted(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected archive of a CRLF text file not to look corrupted")
+	}
+}
@@ -0,0 +1,194 @@
+package cpio
+
+import (
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// maxSymlinkHops is how many symlinks ValidateTree follows before treating
+// a chain as a cycle, as for ELOOP on Linux
+const maxSymlinkHops = 40
+
+// TreeReport lists the problems ValidateTree found in an archive. Paths are
+// cleaned and relative to the archive root, and each list is sorted.
+type TreeReport struct {
+	// MissingParents are directories that contain entries but have no
+	// directory entry of their own, or whose entry is not a directory.
+	MissingParents []string
+
+	// Duplicates are names used by more than one entry.
+	Duplicates []string
+
+	// BrokenSymlinks are symlinks whose target is not in the archive or
+	// points outside it.
+	BrokenSymlinks []string
+
+	// SymlinkCycles are symlinks whose target cannot be resolved because
+	// the symlinks form a loop.
+	SymlinkCycles []string
+}
+
+// OK reports whether no problems were found.
+func (tr *TreeReport) OK() bool {
+	return len(tr.MissingParents) == 0 && len(tr.Duplicates) == 0 &&
+		len(tr.BrokenSymlinks) == 0 && len(tr.SymlinkCycles) == 0
+}
+
+type treeEntry struct {
+	mode   int64
+	target string
+}
+
+// ValidateTree reads the archive from r and checks that its entries form a
+// consistent directory tree for extraction. An error is only returned if
+// the archive cannot be read; problems with the tree are listed in the
+// report.
+func ValidateTree(r io.Reader) (*TreeReport, error) {
+	cr := NewReader(r)
+	entries := make(map[string]treeEntry)
+	dups := make(map[string]bool)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := treePath(hdr.Name)
+		if _, ok := entries[name]; ok {
+			dups[name] = true
+		}
+		e := treeEntry{mode: hdr.Mode &^ 07777}
+		if e.mode == modeSymlink {
+			target, err := ioutil.ReadAll(cr)
+			if err != nil {
+				return nil, err
+			}
+			e.target = string(target)
+		}
+		entries[name] = e
+	}
+
+	tr := &TreeReport{Duplicates: sortedKeys(dups)}
+	missing := make(map[string]bool)
+	for name, e := range entries {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if p, ok := entries[dir]; !ok || p.mode != modeDirectory {
+				missing[dir] = true
+			}
+		}
+		if e.mode != modeSymlink {
+			continue
+		}
+		switch resolveSymlink(entries, name) {
+		case symlinkBroken:
+			tr.BrokenSymlinks = append(tr.BrokenSymlinks, name)
+		case symlinkCycle:
+			tr.SymlinkCycles = append(tr.SymlinkCycles, name)
+		}
+	}
+	tr.MissingParents = sortedKeys(missing)
+	sort.Strings(tr.BrokenSymlinks)
+	sort.Strings(tr.SymlinkCycles)
+	return tr, nil
+}
+
+// TreeNode is a file or directory in the tree built by BuildTree.
+type TreeNode struct {
+	Name     string      // last element of the path, or "" for the root
+	Path     string      // cleaned path relative to the archive root, or "." for the root
+	Header   *Header     // header of the entry, or nil for an implied directory
+	Children []*TreeNode // entries within this one, sorted by Name
+}
+
+// BuildTree reads the archive from r and assembles its entries into a tree
+// by splitting their cleaned names on "/", returning the root. Directories
+// that contain entries but have no entry of their own are added with a nil
+// Header. Entries may appear in any order, and if a name is used more than
+// once the last entry's header is kept.
+func BuildTree(r io.Reader) (*TreeNode, error) {
+	cr := NewReader(r)
+	root := &TreeNode{Path: "."}
+	nodes := map[string]*TreeNode{".": root}
+	var node func(name string) *TreeNode
+	node = func(name string) *TreeNode {
+		if n, ok := nodes[name]; ok {
+			return n
+		}
+		n := &TreeNode{Name: path.Base(name), Path: name}
+		parent := node(path.Dir(name))
+		parent.Children = append(parent.Children, n)
+		nodes[name] = n
+		return n
+	}
+
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		node(treePath(hdr.Name)).Header = hdr
+	}
+
+	for _, n := range nodes {
+		sort.Slice(n.Children, func(i, j int) bool {
+			return n.Children[i].Name < n.Children[j].Name
+		})
+	}
+	return root, nil
+}
+
+// treePath cleans an entry name relative to the archive root
+func treePath(name string) string {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+const (
+	symlinkOK = iota
+	symlinkBroken
+	symlinkCycle
+)
+
+// resolveSymlink follows the symlink entry name to a non-symlink entry.
+// Targets are resolved lexically, so symlinks in the directories leading to
+// a target are not followed.
+func resolveSymlink(entries map[string]treeEntry, name string) int {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		e, ok := entries[name]
+		if !ok {
+			return symlinkBroken
+		}
+		if e.mode != modeSymlink {
+			return symlinkOK
+		}
+		target := e.target
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(name), target)
+			if target == ".." || strings.HasPrefix(target, "../") {
+				return symlinkBroken
+			}
+		}
+		name = treePath(target)
+	}
+	return symlinkCycle
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
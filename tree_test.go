@@ -0,0 +1,113 @@
+package cpio
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateTree(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	add := func(name string, mode int64, body string) {
+		w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: mode, NLink: 1, Size: int64(len(body)), ModTime: testModTime}, strings.NewReader(body))
+	}
+	add("etc", 040755, "")
+	add("etc/passwd", 0100644, "root")
+	add("./etc/passwd", 0100644, "root")
+	add("usr", 040755, "")
+	add("usr/bin", 040755, "")
+	add("usr/bin/sh", 0100755, "sh")
+	add("var/log/messages", 0100644, "")
+	add("bin", 0120777, "usr/bin")
+	add("etc/shell", 0120777, "/usr/bin/sh")
+	add("etc/bin", 0120777, "/bin")
+	add("etc/nosh", 0120777, "/bin/sh")
+	add("etc/missing", 0120777, "nowhere")
+	add("etc/escape", 0120777, "../../outside")
+	add("etc/loop1", 0120777, "loop2")
+	add("etc/loop2", 0120777, "loop1")
+	add("file", 0100644, "x")
+	add("file/child", 0100644, "x")
+	w.Close()
+
+	tr, err := ValidateTree(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &TreeReport{
+		MissingParents: []string{"file", "var", "var/log"},
+		Duplicates:     []string{"etc/passwd"},
+		BrokenSymlinks: []string{"etc/escape", "etc/missing", "etc/nosh"},
+		SymlinkCycles:  []string{"etc/loop1", "etc/loop2"},
+	}
+	if !reflect.DeepEqual(tr, expected) {
+		t.Errorf("expected %+v but got %+v", expected, tr)
+	}
+	if tr.OK() {
+		t.Error("expected OK to be false")
+	}
+
+	tr, err = ValidateTree(bytes.NewReader(testArchive(t, EncodingTypeASCIISVR4, "a", "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.OK() {
+		t.Errorf("expected no problems but got %+v", tr)
+	}
+
+	if _, err = ValidateTree(strings.NewReader("junk")); err == nil || err == io.EOF {
+		t.Error("expected an error for an invalid archive but got:", err)
+	}
+}
+
+func TestBuildTree(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	add := func(name string, mode int64) {
+		w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: mode, NLink: 1, ModTime: testModTime}, strings.NewReader(""))
+	}
+	add("usr/bin/sh", 0100755)
+	add("./etc/", 040755)
+	add("etc/passwd", 0100644)
+	add("/usr/bin/ls", 0100755)
+	add("usr/", 040755)
+	w.Close()
+
+	root, err := BuildTree(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	var walk func(n *TreeNode)
+	walk = func(n *TreeNode) {
+		explicit := "implied"
+		if n.Header != nil {
+			explicit = n.Header.Name
+		}
+		paths = append(paths, n.Path+" "+explicit)
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	expected := []string{
+		". implied",
+		"etc ./etc/",
+		"etc/passwd etc/passwd",
+		"usr usr/",
+		"usr/bin implied",
+		"usr/bin/ls /usr/bin/ls",
+		"usr/bin/sh usr/bin/sh",
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Bad Tree:\nExpected: %q\nActual:   %q", expected, paths)
+	}
+	if root.Children[1].Children[0].Name != "bin" {
+		t.Errorf("expected usr/bin to be named bin but got %q", root.Children[1].Children[0].Name)
+	}
+}
@@ -1,17 +1,35 @@
 package cpio
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"strings"
+	"io/ioutil"
+	"os"
+	"path"
 	"time"
 )
 
 var (
 	ErrWriteAfterClose = errors.New("cpio: write after close")
 	ErrWriteTooLong    = errors.New("cpio: write too long")
+	ErrWriteTooShort   = errors.New("cpio: write too short")
+	ErrEmptySymlink    = errors.New("cpio: symlink entry has no target")
+	ErrNameTooLong     = errors.New("cpio: name too long for header encoding")
+	ErrFieldOverflow   = errors.New("cpio: value too large for header field")
+
+	// ErrCanceled is passed to the CloseWithError method of the underlying
+	// writer, if it has one, by Cancel.
+	ErrCanceled = errors.New("cpio: archive canceled")
+
+	// ErrRewindUnsupported is returned by Rewind if the underlying writer
+	// cannot seek.
+	ErrRewindUnsupported = errors.New("cpio: writer does not support rewinding")
 )
 
 var zeroBlock = make([]byte, 4)
@@ -20,7 +38,108 @@ var zeroBlock = make([]byte, 4)
 // Call WriteHeader to begin a new file, and then call Write to supply
 // that file's data, writing at most hdr.Size bytes in total.
 type Writer struct {
-	w      io.Writer
+	// ComputeChecksum causes the checksum of EncodingTypeASCIISVR4CRC entries
+	// to be calculated from the written data, as by Checksum, ignoring
+	// Header.Checksum.
+	//
+	// The checksum precedes the data in the archive, so the data of each
+	// such entry is buffered in memory until the entry is complete.
+	ComputeChecksum bool
+
+	// CheckFileType causes WriteHeader to derive the expected Size from the
+	// file type in Header.Mode. Directories, devices, FIFOs and sockets are
+	// written with a Size of 0, and symlinks must have a non-zero Size for
+	// the link target, or ErrEmptySymlink is returned.
+	CheckFileType bool
+
+	// BuildIndex causes the location of each entry to be recorded as it is
+	// written, for retrieval with Index.
+	BuildIndex bool
+
+	// TrailerModTime is the modification time written in the trailer by
+	// Close. If zero, the Unix epoch is used.
+	TrailerModTime time.Time
+
+	// TrimDirSlash causes the trailing slash that FileInfoHeader appends to
+	// directory names to be removed when they are written, as some tools
+	// expect. By default repeated trailing slashes are collapsed into one.
+	TrimDirSlash bool
+
+	// AutoInode causes entries with an Inode of 0 to be assigned sequential
+	// inode numbers starting from 1, so that readers relying on inodes, such
+	// as for hard link detection, do not see every entry as the same file.
+	//
+	// Entries with an explicit Inode are left alone, so hard links sharing an
+	// inode stay linked; explicit inodes should be chosen so they do not
+	// collide with the assigned sequence. ErrFieldOverflow is returned once
+	// the sequence exceeds the width of the encoding's inode field.
+	AutoInode bool
+
+	// IDShift is added to the UID and GID of each header before it is
+	// written, such as to map files into a user namespace. ErrFieldOverflow
+	// is returned if a shifted ID is negative or too large for the encoding.
+	//
+	// The shift applies to the IDs as given in each header, so a header
+	// already normalized to owner 0 is written with the shifted IDs.
+	IDShift IDShift
+
+	// PermMask, if non-zero, is ANDed with the permission, setuid, setgid
+	// and sticky bits (07777) of each header's Mode before it is written,
+	// such as 0755 to remove group and other write permission. The file
+	// type bits are left unchanged.
+	PermMask int64
+
+	// ArchiveDigest, if set, is used to compute a digest of every byte
+	// written before the trailer. Close writes the digest in hex as a final
+	// entry named DigestEntryName, which VerifyDigest checks. It must be set
+	// before the first call to WriteHeader, and disables Rewind.
+	ArchiveDigest func() hash.Hash
+
+	// Progress, if set, receives an event as each entry is completed. Sends
+	// do not block, so events are dropped while the channel is full; use a
+	// buffered channel to keep up with small entries. The digest entry and
+	// trailer are not reported, and the channel is not closed.
+	Progress chan<- ProgressEvent
+
+	// AutoDirs causes an entry to be written for each parent directory of
+	// an entry that has not been written already, before the entry itself,
+	// so the archive can be extracted by tools that do not create missing
+	// directories. They are written with mode 0755, the entry's ModTime
+	// and encoding, and an inode from the same sequence as AutoInode.
+	// Directories written explicitly are noted too, but must come before
+	// their contents to avoid duplicates. Nothing is written for an entry
+	// WriteHeader rejects.
+	AutoDirs bool
+
+	// KeepZeroNLink causes entries with an NLink of 0 to be written as is.
+	// By default such entries are written with an NLink of 2 for
+	// directories and 1 for everything else, as some readers reject 0.
+	KeepZeroNLink bool
+
+	// MaxBufferBytes is how much of an entry's data WriteStream holds in
+	// memory while finding its size. Any more is spilled to a temporary
+	// file, which is removed once the entry is written. If zero, 1MiB is
+	// used. With ComputeChecksum, the checksum of a "crc" entry is summed
+	// as its data is buffered, so the data is not held in memory again.
+	MaxBufferBytes int64
+
+	// BlockSize, if non-zero, causes Close to pad the archive with zeros
+	// after the trailer to a multiple of BlockSize bytes. GNU cpio writes
+	// 512 byte blocks by default, and some readers expect the same.
+	BlockSize int
+
+	// ClampModTime causes a ModTime before the Unix epoch to be written as
+	// the epoch. By default WriteHeader returns ErrFieldOverflow for such
+	// times, as the mtime field is unsigned.
+	ClampModTime bool
+
+	// CommentName is the name of the entry written by WriteComment. If
+	// empty, CommentEntryName is used.
+	CommentName string
+
+	w      *countWriter
+	bw     *bufio.Writer
+	dst    io.Writer
 	err    error
 	closed bool
 	nb     int64
@@ -28,46 +147,340 @@ type Writer struct {
 	first  bool
 	enc    EncodingType
 	hdrBuf []byte
+
+	trailerEnc    EncodingType
+	trailerEncSet bool
+
+	onClose func() error
+
+	crcHdr *Header
+	crcBuf bytes.Buffer
+	crcSum uint32
+	// crcKnown is set while WriteStream writes an entry whose checksum it
+	// has already summed
+	crcKnown bool
+
+	index []IndexEntry
+	inode int
+	good  int64
+
+	progress ProgressEvent
+	dirs     map[string]int64
+	pending  bool
+}
+
+// IDShift is an offset added to user and group IDs
+type IDShift struct {
+	UID, GID int
+}
+
+// countWriter counts the bytes written through it, and adds them to h if set
+type countWriter struct {
+	w io.Writer
+	n int64
+	h hash.Hash
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	if c.h != nil {
+		c.h.Write(b[:n])
+	}
+	return n, err
 }
 
-// NewWriter creates a new Writer writing to w
+// NewWriter creates a new Writer writing to w.
+//
+// The trailer is written in the encoding of the first header, or odc if
+// the archive is closed without any entries.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{w: w}
+	return &Writer{w: &countWriter{w: w}}
+}
+
+// NewWriterSize creates a new Writer writing to w through a buffer of at
+// least size bytes, so that headers and small writes do not each reach w.
+// The buffer is written to w by Flush and Close.
+func NewWriterSize(w io.Writer, size int) *Writer {
+	bw := bufio.NewWriterSize(w, size)
+	cw := NewWriter(bw)
+	cw.bw = bw
+	cw.dst = w
+	return cw
+}
+
+// NewWriterEncoding creates a new Writer writing to w whose trailer is always
+// written in enc, including when the archive is closed without any entries.
+func NewWriterEncoding(w io.Writer, enc EncodingType) *Writer {
+	cw := NewWriter(w)
+	cw.first = true
+	cw.enc = enc
+	return cw
+}
+
+// SetTrailerEncoding sets the encoding of the trailer written by Close,
+// in place of the encoding of the first entry, such as to match an
+// existing archive being appended to when no entries are written.
+func (cw *Writer) SetTrailerEncoding(enc EncodingType) {
+	cw.trailerEnc = enc
+	cw.trailerEncSet = true
 }
 
 // Close closes the cpio archive, flushing any unwritten data to the underlying writer.
+//
+// Closing a Writer with no entries writes just the trailer, which is a valid
+// empty archive. Calling Close again has no effect.
+//
+// If fewer than Size bytes were written for the last entry, Close returns
+// ErrWriteTooShort without writing the trailer, leaving the output visibly
+// truncated.
 func (cw *Writer) Close() error {
 	if cw.err != nil || cw.closed {
 		return cw.err
 	}
+	if cw.nb > 0 {
+		// a trailer after a short body would hide the truncation
+		cw.err = ErrWriteTooShort
+		return cw.err
+	}
 
-	cw.WriteHeader(&Header{
-		Encoding: cw.enc,
-		Name:     "TRAILER!!!",
-		NLink:    1,
-		ModTime:  time.Unix(0, 0),
-	})
+	if cw.err == nil {
+		cw.flushEntry()
+	}
+	// the trailer is not an entry, so a failed Close rewinds to before it
+	good := cw.good
+	modTime := cw.TrailerModTime
+	if modTime.IsZero() {
+		modTime = time.Unix(0, 0)
+	}
+	if cw.err == nil && cw.ArchiveDigest != nil {
+		cw.writeDigest(modTime)
+	}
+	enc := cw.enc
+	if cw.trailerEncSet {
+		enc = cw.trailerEnc
+	}
+	if cw.err == nil {
+		cw.encodeHeader(&Header{
+			Encoding: enc,
+			Name:     "TRAILER!!!",
+			NLink:    1,
+			ModTime:  modTime,
+		})
+	}
 
-	cw.Flush()
+	cw.flushEntry()
+	if cw.err == nil && cw.BlockSize > 0 {
+		if rem := cw.w.n % int64(cw.BlockSize); rem > 0 {
+			_, cw.err = cw.w.Write(make([]byte, int64(cw.BlockSize)-rem))
+		}
+	}
+	if cw.err == nil && cw.bw != nil {
+		cw.err = cw.bw.Flush()
+	}
+	cw.good = good
 	cw.closed = true
+	if cw.err == nil && cw.onClose != nil {
+		cw.err = cw.onClose()
+	}
 
 	return cw.err
 }
 
-// Flush finishes writing the current file (optional).
+// Cancel abandons the archive without finishing the current entry or
+// writing the trailer, so a partial archive is not mistaken for a complete
+// one. Data buffered by a Writer created by NewWriterSize is discarded.
+//
+// If the underlying writer has a CloseWithError(error) error method, as
+// *io.PipeWriter does, it is called with ErrCanceled so the reader sees the
+// archive was abandoned. After Cancel, Write and WriteHeader return
+// ErrWriteAfterClose and Close does nothing.
+func (cw *Writer) Cancel() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	dst := cw.w.w
+	if cw.bw != nil {
+		dst = cw.dst
+		cw.bw.Reset(dst)
+	}
+	if c, ok := dst.(interface{ CloseWithError(error) error }); ok {
+		return c.CloseWithError(ErrCanceled)
+	}
+	return nil
+}
+
+// writeDigest writes the digest of everything written so far as an entry
+func (cw *Writer) writeDigest(modTime time.Time) {
+	if cw.w.h == nil {
+		cw.w.h = cw.ArchiveDigest()
+	}
+	sum := hex.EncodeToString(cw.w.h.Sum(nil))
+	cw.w.h = nil
+	if cw.writeHeader(&Header{
+		Encoding: cw.enc,
+		Name:     DigestEntryName,
+		Mode:     0100444,
+		NLink:    1,
+		ModTime:  modTime,
+		Size:     int64(len(sum)),
+	}, nil) != nil {
+		return
+	}
+	_, cw.err = io.WriteString(cw.w, sum)
+	cw.nb = 0
+	if cw.err == nil {
+		cw.flushEntry()
+	}
+}
+
+// Flush finishes writing the current file (optional), and writes any
+// buffered data to the underlying writer of a Writer created by NewWriterSize.
+// It returns an error wrapping ErrWriteTooShort if fewer than Size bytes of
+// the current file have been written.
 func (cw *Writer) Flush() error {
-	if cw.nb > 0 {
-		cw.err = fmt.Errorf("cpio: missed writing %d bytes", cw.nb)
+	if cw.flushEntry() != nil || cw.bw == nil {
 		return cw.err
 	}
-	if cw.pad == 0 {
+	cw.err = cw.bw.Flush()
+	return cw.err
+}
+
+// flushEntry finishes writing the current file, if any, without flushing
+// the buffer of a Writer created by NewWriterSize
+func (cw *Writer) flushEntry() error {
+	if cw.nb > 0 {
+		cw.err = fmt.Errorf("%w: missed writing %d bytes", ErrWriteTooShort, cw.nb)
 		return cw.err
 	}
-	_, cw.err = cw.w.Write(zeroBlock[:cw.pad])
-	cw.pad = 0
+	if cw.crcHdr != nil {
+		// the entry is complete, so its checksum is now known
+		hdr := cw.crcHdr
+		cw.crcHdr = nil
+		hdr.Checksum = cw.crcSum
+		if cw.writeHeader(hdr, nil) != nil {
+			return cw.err
+		}
+		_, cw.err = cw.crcBuf.WriteTo(cw.w)
+		cw.nb = 0
+		if cw.err != nil {
+			return cw.err
+		}
+	}
+	if cw.pad > 0 {
+		_, cw.err = cw.w.Write(zeroBlock[:cw.pad])
+		cw.pad = 0
+	}
+	if cw.err == nil {
+		cw.good = cw.w.n
+		if cw.pending {
+			cw.pending = false
+			cw.sendProgress()
+		}
+	}
 	return cw.err
 }
 
+// ProgressEvent reports an entry completed by a Writer, along with totals
+// for the archive so far.
+type ProgressEvent struct {
+	Name         string // name of the entry
+	Size         int64  // length of the entry's data
+	Entries      int    // number of entries completed
+	DataBytes    int64  // sum of the data lengths of completed entries
+	ArchiveBytes int64  // bytes written, including headers and padding
+}
+
+// startProgress records hdr as the entry in progress if Progress is set
+func (cw *Writer) startProgress(hdr *Header) {
+	if cw.Progress == nil {
+		return
+	}
+	cw.progress.Name = hdr.Name
+	cw.progress.Size = hdr.Size
+	cw.pending = true
+}
+
+// sendProgress reports the entry just completed without blocking
+func (cw *Writer) sendProgress() {
+	cw.progress.Entries++
+	cw.progress.DataBytes += cw.progress.Size
+	cw.progress.ArchiveBytes = cw.w.n
+	select {
+	case cw.Progress <- cw.progress:
+	default:
+	}
+}
+
+// LastGoodOffset returns the number of bytes written to the underlying
+// writer up to the end of the last completed entry, including its padding.
+// Data of an entry still in progress, or of one that failed, is not counted.
+func (cw *Writer) LastGoodOffset() int64 {
+	return cw.good
+}
+
+// Rewind discards anything written after LastGoodOffset, such as an entry
+// interrupted by a write error, and clears the error so that writing can
+// resume with the next call to WriteHeader.
+//
+// The underlying writer must implement io.Seeker, and ArchiveDigest must not
+// be set, or ErrRewindUnsupported is returned. If it also has a
+// Truncate(int64) error method, as *os.File does, it is truncated to the
+// rewound position. Rewinding a Writer that was closed successfully returns
+// ErrWriteAfterClose; one whose Close failed may be closed again after
+// rewinding.
+func (cw *Writer) Rewind() error {
+	if cw.closed && cw.err == nil {
+		return ErrWriteAfterClose
+	}
+	dst, unflushed := cw.w.w, 0
+	if cw.bw != nil {
+		dst = cw.dst
+	}
+	s, ok := dst.(io.Seeker)
+	if !ok || cw.ArchiveDigest != nil {
+		return ErrRewindUnsupported
+	}
+	if cw.bw != nil {
+		// data still buffered after a failed flush never reached dst
+		err := cw.bw.Flush()
+		unflushed = cw.bw.Buffered()
+		if cw.w.n-int64(unflushed) < cw.good {
+			// completed entries would be lost with the buffer
+			return err
+		}
+		cw.bw.Reset(dst)
+	}
+	pos, err := s.Seek(cw.good-cw.w.n+int64(unflushed), io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if t, ok := s.(interface{ Truncate(int64) error }); ok {
+		err = t.Truncate(pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.w.n = cw.good
+	cw.err = nil
+	cw.closed = false
+	cw.nb = 0
+	cw.pad = 0
+	cw.crcHdr = nil
+	cw.pending = false
+	for len(cw.index) > 0 && cw.index[len(cw.index)-1].Offset >= cw.good {
+		cw.index = cw.index[:len(cw.index)-1]
+	}
+	for name, off := range cw.dirs {
+		if off >= cw.good {
+			delete(cw.dirs, name)
+		}
+	}
+	return nil
+}
+
 // Write writes to the current entry in the tar archive.
 // Write returns the error ErrWriteTooLong if more than
 // hdr.Size bytes are written after WriteHeader.
@@ -80,7 +493,14 @@ func (cw *Writer) Write(b []byte) (int, error) {
 		b = b[:cw.nb]
 		overwrite = true
 	}
-	n, err := cw.w.Write(b)
+	var n int
+	var err error
+	if cw.crcHdr != nil {
+		n, err = cw.crcBuf.Write(b)
+		cw.crcSum = sumBytes(cw.crcSum, b[:n])
+	} else {
+		n, err = cw.w.Write(b)
+	}
 	cw.nb -= int64(n)
 	if err == nil && overwrite {
 		return n, ErrWriteTooLong
@@ -90,14 +510,149 @@ func (cw *Writer) Write(b []byte) (int, error) {
 }
 
 // WriteHeader writes hdr and prepares to accept the file's contents.
-// WriteHeader calls Flush if it is not the first header. Calling
-// after a Close will return ErrWriteAfterClose.
+// WriteHeader finishes the previous file, as Flush does, if it is not the
+// first header. Calling after a Close will return ErrWriteAfterClose.
+//
+// A zero hdr.ModTime is written as the Unix epoch.
 func (cw *Writer) WriteHeader(hdr *Header) error {
+	err := cw.beginHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	// TODO: what happens if we get different header formats?
+
+	if name := cw.dirName(hdr); name != hdr.Name {
+		h := *hdr
+		h.Name = name
+		hdr = &h
+	}
+
+	if int64(len(hdr.Name))+1 > maxField(hdr.Encoding) {
+		return ErrNameTooLong
+	}
+
+	if hdr.Mode < 0 || hdr.Mode > maxField(hdr.Encoding) {
+		return fmt.Errorf("%w: mode %o", ErrFieldOverflow, hdr.Mode)
+	}
+
+	switch hdr.Encoding {
+	case EncodingTypeASCIISUSv2, EncodingTypeBinaryLE, EncodingTypeBinaryBE:
+		// major and minor share one field, with 8 bits for the minor
+		if hdr.DevMinor < 0 || hdr.DevMinor > 0xff || hdr.DevMajor < 0 || packDev(hdr.DevMajor, hdr.DevMinor) > maxField(hdr.Encoding) {
+			return fmt.Errorf("%w: dev %d, %d", ErrFieldOverflow, hdr.DevMajor, hdr.DevMinor)
+		}
+		if hdr.RDevMinor < 0 || hdr.RDevMinor > 0xff || hdr.RDevMajor < 0 || packDev(hdr.RDevMajor, hdr.RDevMinor) > maxField(hdr.Encoding) {
+			return fmt.Errorf("%w: rdev %d, %d", ErrFieldOverflow, hdr.RDevMajor, hdr.RDevMinor)
+		}
+	case EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC:
+		// each number has a field of its own
+		max := maxField(hdr.Encoding)
+		if hdr.DevMajor < 0 || int64(hdr.DevMajor) > max || hdr.DevMinor < 0 || int64(hdr.DevMinor) > max {
+			return fmt.Errorf("%w: dev %d, %d", ErrFieldOverflow, hdr.DevMajor, hdr.DevMinor)
+		}
+		if hdr.RDevMajor < 0 || int64(hdr.RDevMajor) > max || hdr.RDevMinor < 0 || int64(hdr.RDevMinor) > max {
+			return fmt.Errorf("%w: rdev %d, %d", ErrFieldOverflow, hdr.RDevMajor, hdr.RDevMinor)
+		}
+	}
+
+	if mt := modTimeUnix(hdr.ModTime); mt < 0 {
+		if !cw.ClampModTime {
+			return fmt.Errorf("%w: mtime %d", ErrFieldOverflow, mt)
+		}
+		h := *hdr
+		h.ModTime = time.Unix(0, 0)
+		hdr = &h
+	}
+
+	if cw.AutoInode && hdr.Inode == 0 {
+		if int64(cw.inode+1) > maxField(hdr.Encoding) {
+			return fmt.Errorf("%w: inode %d", ErrFieldOverflow, cw.inode+1)
+		}
+		cw.inode++
+		h := *hdr
+		h.Inode = cw.inode
+		hdr = &h
+	}
+
+	if cw.PermMask != 0 && hdr.Mode&07777&^cw.PermMask != 0 {
+		h := *hdr
+		h.Mode &^= 07777 &^ cw.PermMask
+		hdr = &h
+	}
+
+	if cw.IDShift != (IDShift{}) {
+		h := *hdr
+		h.UID += cw.IDShift.UID
+		h.GID += cw.IDShift.GID
+		if h.UID < 0 || int64(h.UID) > maxField(h.Encoding) {
+			return fmt.Errorf("%w: uid %d", ErrFieldOverflow, h.UID)
+		}
+		if h.GID < 0 || int64(h.GID) > maxField(h.Encoding) {
+			return fmt.Errorf("%w: gid %d", ErrFieldOverflow, h.GID)
+		}
+		hdr = &h
+	}
+
+	if hdr.NLink == 0 && !cw.KeepZeroNLink {
+		h := *hdr
+		h.NLink = 1
+		if h.Mode&^07777 == modeDirectory {
+			h.NLink = 2
+		}
+		hdr = &h
+	}
+
+	if cw.CheckFileType {
+		switch hdr.Mode &^ 07777 {
+		case modeDirectory, modeCharDev, modeBlkDev, modeFIFO, modeSocket:
+			if hdr.Size != 0 {
+				h := *hdr
+				h.Size = 0
+				hdr = &h
+			}
+		case modeSymlink:
+			if hdr.Size == 0 {
+				return ErrEmptySymlink
+			}
+		}
+	}
+
+	if err := checkFields(hdr); err != nil {
+		return err
+	}
+
+	if cw.AutoDirs {
+		// only once hdr is known to be valid, so a rejected entry leaves
+		// no directories behind
+		if cw.writeDirs(hdr) != nil || cw.flushEntry() != nil {
+			return cw.err
+		}
+	}
+
+	cw.startProgress(hdr)
+
+	if cw.ComputeChecksum && !cw.crcKnown && hdr.Encoding == EncodingTypeASCIISVR4CRC {
+		// defer writing the header until the data has been summed
+		h := *hdr
+		cw.crcHdr = &h
+		cw.crcSum = 0
+		cw.crcBuf.Reset()
+		cw.nb = hdr.Size
+		cw.pad = 0
+		return nil
+	}
+
+	return cw.writeHeader(hdr, nil)
+}
+
+// beginHeader finishes the previous entry before hdr is written
+func (cw *Writer) beginHeader(hdr *Header) error {
 	if cw.closed {
 		return ErrWriteAfterClose
 	}
 	if cw.err == nil {
-		cw.Flush()
+		cw.flushEntry()
 	}
 
 	// flush could have set an error, so don't use `else`
@@ -108,9 +663,291 @@ func (cw *Writer) WriteHeader(hdr *Header) error {
 		cw.first = true
 		cw.enc = hdr.Encoding
 	}
+	if cw.ArchiveDigest != nil && cw.w.h == nil {
+		cw.w.h = cw.ArchiveDigest()
+	}
+	return nil
+}
+
+// writeDirs writes entries for the parent directories of hdr that have not
+// been written yet, shallowest first
+func (cw *Writer) writeDirs(hdr *Header) error {
+	self := path.Clean(hdr.Name)
+	// keep the style of the entry's name, such as a leading "./" or "/"
+	for i := 1; i < len(hdr.Name); i++ {
+		if hdr.Name[i] != '/' {
+			continue
+		}
+		clean := path.Clean(hdr.Name[:i])
+		if _, ok := cw.dirs[clean]; ok || clean == "." || clean == "/" || clean == self {
+			continue
+		}
+		name := clean
+		if hdr.Name[0] == '.' && hdr.Name[1] == '/' {
+			name = "./" + clean
+		}
+		// numbered from the same sequence as AutoInode, so readers don't
+		// take the directories for hard links of each other
+		if int64(cw.inode+1) > maxField(cw.enc) {
+			return fmt.Errorf("%w: inode %d", ErrFieldOverflow, cw.inode+1)
+		}
+		cw.inode++
+		err := cw.WriteHeaderOnly(&Header{
+			Encoding: cw.enc,
+			Name:     name,
+			Mode:     modeDirectory | 0755,
+			Inode:    cw.inode,
+			NLink:    2,
+			ModTime:  hdr.ModTime,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// TODO: what happens if we get different header formats?
+// WriteRaw writes the header of an entry read with Reader.KeepRaw and
+// prepares to accept its contents, as WriteHeader does.
+//
+// If the entry is unmodified its header and name are copied exactly as
+// read, including any padding and formatting quirks. Otherwise, or if
+// the Writer has options set that would change the header, it is
+// re-encoded from e.Header by WriteHeader.
+func (cw *Writer) WriteRaw(e *RawEntry) error {
+	if e.Modified() || cw.rewrites(&e.Header) {
+		return cw.WriteHeader(&e.Header)
+	}
+	err := cw.beginHeader(&e.Header)
+	if err != nil {
+		return err
+	}
+	if cw.AutoDirs {
+		if cw.writeDirs(&e.Header) != nil || cw.flushEntry() != nil {
+			return cw.err
+		}
+	}
+	cw.startProgress(&e.Header)
+	return cw.writeHeader(&e.Header, e.raw)
+}
+
+// rewrites reports whether the options of cw would change hdr as written
+func (cw *Writer) rewrites(hdr *Header) bool {
+	fileType := hdr.Mode &^ 07777
+	return cw.ComputeChecksum && hdr.Encoding == EncodingTypeASCIISVR4CRC ||
+		cw.CheckFileType ||
+		fileType == modeDirectory && cw.dirName(hdr) != hdr.Name ||
+		cw.AutoInode && hdr.Inode == 0 ||
+		cw.PermMask != 0 && hdr.Mode&07777&^cw.PermMask != 0 ||
+		cw.IDShift != (IDShift{}) ||
+		hdr.NLink == 0 && !cw.KeepZeroNLink
+}
+
+// dirName returns the name hdr is written with, with the trailing slash of
+// a directory removed if TrimDirSlash is set, or normalized otherwise
+func (cw *Writer) dirName(hdr *Header) string {
+	if cw.TrimDirSlash {
+		return hdr.CleanName()
+	}
+	return hdr.normalizeDirName()
+}
+
+// WriteHeaderOnly writes hdr for an entry without data, such as a directory,
+// device or empty file, and completes it. An error is returned if hdr.Size
+// is not 0.
+func (cw *Writer) WriteHeaderOnly(hdr *Header) error {
+	if hdr.Size != 0 {
+		return fmt.Errorf("cpio: header-only entry %s has size %d", hdr.Name, hdr.Size)
+	}
+	err := cw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	return cw.flushEntry()
+}
+
+// AddReader writes hdr followed by exactly hdr.Size bytes of data from r,
+// completing the entry. ErrWriteTooShort is returned if r ends early, and
+// ErrWriteTooLong if it holds more data.
+func (cw *Writer) AddReader(hdr *Header, r io.Reader) error {
+	err := cw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyN(cw, r, cw.nb)
+	if err == io.EOF {
+		return ErrWriteTooShort
+	}
+	if err != nil {
+		return err
+	}
+
+	var extra [1]byte
+	n, err := io.ReadFull(r, extra[:])
+	if n > 0 {
+		return ErrWriteTooLong
+	}
+	if err != io.EOF {
+		return err
+	}
+
+	return cw.flushEntry()
+}
+
+// WriteSection writes hdr followed by the length bytes of data at offset
+// off in r, completing the entry. It is useful when entry data lives in a
+// memory-mapped file or other store addressed by offset. An error is
+// returned if length is not hdr.Size, and ErrWriteTooShort if r ends early.
+func (cw *Writer) WriteSection(hdr *Header, r io.ReaderAt, off, length int64) error {
+	if length != hdr.Size {
+		return fmt.Errorf("cpio: section length %d does not match size %d of %s", length, hdr.Size, hdr.Name)
+	}
+	err := cw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(cw, io.NewSectionReader(r, off, length))
+	if err != nil {
+		return err
+	}
+	if n < length {
+		return ErrWriteTooShort
+	}
+
+	return cw.flushEntry()
+}
+
+// WriteStream writes hdr followed by all the data from r, for an entry
+// whose size is not known in advance; hdr.Size is ignored. As the size
+// precedes the data in the archive, the data is buffered until r ends: in
+// memory up to MaxBufferBytes, then in a temporary file in os.TempDir.
+func (cw *Writer) WriteStream(hdr *Header, r io.Reader) error {
+	limit := cw.MaxBufferBytes
+	if limit <= 0 {
+		limit = 1 << 20
+	}
+	sum := new(checksum)
+	r = io.TeeReader(r, sum)
+	var mem bytes.Buffer
+	size, err := io.Copy(&mem, io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+
+	body := io.Reader(&mem)
+	if size > limit {
+		f, err := ioutil.TempFile("", "cpio-stream-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
 
+		_, err = mem.WriteTo(f)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(f, r)
+		if err != nil {
+			return err
+		}
+		size += n
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		body = f
+	}
+
+	h := *hdr
+	h.Size = size
+	if cw.ComputeChecksum && h.Encoding == EncodingTypeASCIISVR4CRC {
+		h.Checksum = sum.Sum32()
+		cw.crcKnown = true
+		defer func() { cw.crcKnown = false }()
+	}
+	return cw.AddReader(&h, body)
+}
+
+// WriteGenerated writes hdr followed by the data gen writes to w,
+// completing the entry. It is useful when entry data is produced on demand,
+// such as a generated config file. gen must write exactly hdr.Size bytes;
+// if it writes more, ErrWriteTooLong is returned even if gen ignored the
+// error from w, and if it writes less, ErrWriteTooShort is returned.
+func (cw *Writer) WriteGenerated(hdr *Header, gen func(w io.Writer) error) error {
+	err := cw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	gw := &generatedWriter{cw: cw}
+	err = gen(gw)
+	if gw.long {
+		return fmt.Errorf("%w: %s generated more than %d bytes", ErrWriteTooLong, hdr.Name, hdr.Size)
+	}
+	if err != nil {
+		return err
+	}
+	if gw.n < hdr.Size {
+		return fmt.Errorf("%w: %s generated %d of %d bytes", ErrWriteTooShort, hdr.Name, gw.n, hdr.Size)
+	}
+
+	return cw.flushEntry()
+}
+
+// generatedWriter is given to the function passed to WriteGenerated, so
+// that it can only write data and overflows are noticed
+type generatedWriter struct {
+	cw   *Writer
+	n    int64
+	long bool
+}
+
+func (gw *generatedWriter) Write(b []byte) (int, error) {
+	n, err := gw.cw.Write(b)
+	gw.n += int64(n)
+	if err == ErrWriteTooLong {
+		gw.long = true
+	}
+	return n, err
+}
+
+// writeHeader encodes hdr, or writes raw if it is not nil, recording its
+// location if BuildIndex is set
+func (cw *Writer) writeHeader(hdr *Header, raw []byte) error {
+	off := cw.w.n
+	var err error
+	if raw != nil {
+		_, cw.err = cw.w.Write(raw)
+		cw.nb = hdr.Size
+		cw.pad = dataPad(hdr.Encoding, hdr.Size)
+		err = cw.err
+	} else {
+		err = cw.encodeHeader(hdr)
+	}
+	if err == nil && cw.AutoDirs && hdr.Mode&^07777 == modeDirectory {
+		// noted once written, so a directory that fails is still written
+		// for the entries inside it
+		if cw.dirs == nil {
+			cw.dirs = make(map[string]int64)
+		}
+		cw.dirs[path.Clean(hdr.Name)] = off
+	}
+	if err == nil && cw.BuildIndex {
+		cw.index = append(cw.index, IndexEntry{
+			Name:       hdr.Name,
+			Offset:     off,
+			HeaderSize: cw.w.n - off,
+			Size:       hdr.Size,
+			Pad:        cw.pad,
+		})
+	}
+	return err
+}
+
+func (cw *Writer) encodeHeader(hdr *Header) error {
 	switch hdr.Encoding {
 	case EncodingTypeBinaryBE:
 		return cw.writeBinary(hdr, binary.BigEndian)
@@ -125,31 +962,99 @@ func (cw *Writer) WriteHeader(hdr *Header) error {
 	}
 }
 
+// modTimeUnix returns t as a Unix time, treating the zero time.Time as the
+// epoch rather than a date far outside the range of any format.
+func modTimeUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// checkFields returns ErrFieldOverflow if a field of hdr can't be written in
+// its encoding, as the header would otherwise disagree with the data.
+func checkFields(hdr *Header) error {
+	max := maxField(hdr.Encoding)
+	for _, f := range [...]struct {
+		name string
+		v    int64
+	}{
+		{"inode", int64(hdr.Inode)},
+		{"uid", int64(hdr.UID)},
+		{"gid", int64(hdr.GID)},
+		{"nlink", int64(hdr.NLink)},
+	} {
+		if f.v < 0 || f.v > max {
+			return fmt.Errorf("%w: %s %d", ErrFieldOverflow, f.name, f.v)
+		}
+	}
+	if mt := modTimeUnix(hdr.ModTime); mt > maxWideField(hdr.Encoding) {
+		return fmt.Errorf("%w: mtime %d", ErrFieldOverflow, mt)
+	}
+	if hdr.Size < 0 || hdr.Size > maxWideField(hdr.Encoding) {
+		return fmt.Errorf("%w: size %d", ErrFieldOverflow, hdr.Size)
+	}
+	return nil
+}
+
+// maxWideField returns the largest value the mtime and filesize fields of
+// enc can hold.
+func maxWideField(enc EncodingType) int64 {
+	switch enc {
+	case EncodingTypeASCIISUSv2:
+		return 077777777777
+	default:
+		return 0xffffffff
+	}
+}
+
+// maxField returns the largest value the inode, mode, uid, gid, nlink and
+// namesize fields of enc can hold.
+func maxField(enc EncodingType) int64 {
+	switch enc {
+	case EncodingTypeBinaryLE, EncodingTypeBinaryBE:
+		return 0xffff
+	case EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC:
+		return 0xffffffff
+	default:
+		return 0777777
+	}
+}
+
+// Index returns the location of each entry written so far, excluding the
+// trailer. It is only populated if BuildIndex is set.
+func (cw *Writer) Index() []IndexEntry {
+	return cw.index
+}
+
 func (cw *Writer) nextASCIISVR4(hdr *Header) error {
 	nameLen := len(hdr.Name) + 1
-	var namePad string
-	rem := (nameLen + 2) % 4
-	if rem > 0 {
-		namePad = strings.Repeat("\x00", 4-rem)
-	}
-	_, cw.err = fmt.Fprintf(cw.w, "07070%d%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%s\x00%s",
-		hdr.Encoding,
-		hdr.Inode,
+	// the checksum field must be zero in newc archives
+	magic, checksum := MagicNewc, uint32(0)
+	if hdr.Encoding == EncodingTypeASCIISVR4CRC {
+		magic, checksum = MagicCRC, hdr.Checksum
+	}
+
+	b := cw.headerBuf(HeaderSizeNewc, hdr.Name, int(headerSize(hdr.Encoding, nameLen)))
+	copy(b, magic)
+	for i, v := range [...]int64{
+		int64(hdr.Inode),
 		hdr.Mode,
-		hdr.UID,
-		hdr.GID,
-		hdr.NLink,
-		hdr.ModTime.Unix(),
+		int64(hdr.UID),
+		int64(hdr.GID),
+		int64(hdr.NLink),
+		modTimeUnix(hdr.ModTime),
 		hdr.Size,
-		hdr.DevMajor,
-		hdr.DevMinor,
-		hdr.RDevMajor,
-		hdr.RDevMinor,
-		nameLen,
-		hdr.Checksum,
-		hdr.Name,
-		namePad,
-	)
+		int64(hdr.DevMajor),
+		int64(hdr.DevMinor),
+		int64(hdr.RDevMajor),
+		int64(hdr.RDevMinor),
+		int64(nameLen),
+		int64(checksum),
+	} {
+		putHex(b[6+i*8:14+i*8], v)
+	}
+	_, cw.err = cw.w.Write(b)
 
 	cw.pad = hdr.Size % 4
 	if cw.pad > 0 {
@@ -161,54 +1066,47 @@ func (cw *Writer) nextASCIISVR4(hdr *Header) error {
 }
 
 func (cw *Writer) nextASCIISUSv2(hdr *Header) error {
-	_, cw.err = fmt.Fprintf(cw.w, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o%s\x00",
-		hdr.DevMinor,
-		hdr.Inode,
-		hdr.Mode,
-		hdr.UID,
-		hdr.GID,
-		hdr.NLink,
-		hdr.RDevMinor,
-		hdr.ModTime.Unix(),
-		len(hdr.Name)+1,
-		hdr.Size,
-		hdr.Name,
-	)
+	b := cw.headerBuf(HeaderSizeODC, hdr.Name, HeaderSizeODC+len(hdr.Name)+1)
+	copy(b, MagicODC)
+	putOctal(b[6:12], packDev(hdr.DevMajor, hdr.DevMinor))
+	putOctal(b[12:18], int64(hdr.Inode))
+	putOctal(b[18:24], hdr.Mode)
+	putOctal(b[24:30], int64(hdr.UID))
+	putOctal(b[30:36], int64(hdr.GID))
+	putOctal(b[36:42], int64(hdr.NLink))
+	putOctal(b[42:48], packDev(hdr.RDevMajor, hdr.RDevMinor))
+	putOctal(b[48:59], modTimeUnix(hdr.ModTime))
+	putOctal(b[59:65], int64(len(hdr.Name)+1))
+	putOctal(b[65:76], hdr.Size)
+	_, cw.err = cw.w.Write(b)
+
 	cw.pad = 0
 	cw.nb = hdr.Size
 	return cw.err
 }
 
 func (cw *Writer) writeBinary(hdr *Header, bo binary.ByteOrder) error {
-	cw.err = binary.Write(cw.w, bo, uint16(070707))
-	if cw.err != nil {
-		return cw.err
-	}
-
-	var h binaryHeader
-	h.Dev = uint16(hdr.DevMinor)
-	h.Filesize[0] = uint16(hdr.Size / 65536)
-	h.Filesize[1] = uint16(hdr.Size % 65536)
-	h.GID = uint16(hdr.GID)
-	h.Inode = uint16(hdr.Inode)
-	h.Mode = uint16(hdr.Mode)
-	mt := hdr.ModTime.Unix()
-	h.ModTime[0] = uint16(mt / 65536)
-	h.ModTime[1] = uint16(mt % 65536)
 	nlen := len(hdr.Name) + 1
-	h.Namesize = uint16(nlen)
-	h.NLink = uint16(hdr.NLink)
-	h.RDev = uint16(hdr.RDevMinor)
-	h.UID = uint16(hdr.UID)
-
-	cw.err = binary.Write(cw.w, bo, &h)
-	if cw.err != nil {
-		return cw.err
+	b := cw.headerBuf(HeaderSizeBinary, hdr.Name, int(headerSize(hdr.Encoding, nlen)))
+	mt := modTimeUnix(hdr.ModTime)
+	for i, v := range [...]int64{
+		MagicBinary,
+		packDev(hdr.DevMajor, hdr.DevMinor),
+		int64(hdr.Inode),
+		hdr.Mode,
+		int64(hdr.UID),
+		int64(hdr.GID),
+		int64(hdr.NLink),
+		packDev(hdr.RDevMajor, hdr.RDevMinor),
+		mt / 65536,
+		mt % 65536,
+		int64(nlen),
+		hdr.Size / 65536,
+		hdr.Size % 65536,
+	} {
+		bo.PutUint16(b[i*2:], uint16(v))
 	}
-
-	nameBuf := make([]byte, nlen+nlen%2)
-	copy(nameBuf, hdr.Name)
-	_, cw.err = cw.w.Write(nameBuf)
+	_, cw.err = cw.w.Write(b)
 	if cw.err != nil {
 		return cw.err
 	}
@@ -217,3 +1115,39 @@ func (cw *Writer) writeBinary(hdr *Header, bo binary.ByteOrder) error {
 	cw.pad = hdr.Size % 2
 	return nil
 }
+
+// headerBuf returns hdrBuf resized to n bytes, with name and its NUL
+// terminator and padding written after the first size bytes.
+func (cw *Writer) headerBuf(size int, name string, n int) []byte {
+	if cap(cw.hdrBuf) < n {
+		cw.hdrBuf = make([]byte, n)
+	}
+	b := cw.hdrBuf[:n]
+	copy(b[size:], name)
+	for i := size + len(name); i < n; i++ {
+		b[i] = 0
+	}
+	return b
+}
+
+const hexDigits = "0123456789ABCDEF"
+
+// putHex formats v into b as len(b) uppercase hex digits. Values too large
+// for the field keep only their low digits; WriteHeader rejects such values
+// with checkFields before they get here.
+func putHex(b []byte, v int64) {
+	u := uint64(v)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = hexDigits[u&0xf]
+		u >>= 4
+	}
+}
+
+// putOctal formats v into b as len(b) octal digits, as putHex does.
+func putOctal(b []byte, v int64) {
+	u := uint64(v)
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte('0' + u&7)
+		u >>= 3
+	}
+}
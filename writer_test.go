@@ -2,12 +2,45 @@ package cpio
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
 
+// testArchive builds an archive of regular files from name/body pairs
+func testArchive(t *testing.T, enc EncodingType, nameBody ...string) []byte {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for i := 0; i < len(nameBody); i += 2 {
+		err := w.WriteHeader(&Header{
+			Encoding: enc,
+			Name:     nameBody[i],
+			Mode:     0100644,
+			NLink:    1,
+			Size:     int64(len(nameBody[i+1])),
+			ModTime:  testModTime,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.WriteString(w, nameBody[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
 func testWriterType(t *testing.T, file string, enc EncodingType) {
 	t.Run(enc.String(), func(t *testing.T) {
 
@@ -64,3 +97,1053 @@ func TestWriter(t *testing.T) {
 	testWriterType(t, "test-data/ascii-svr4-crc.cpio", EncodingTypeASCIISVR4CRC)
 	testWriterType(t, "test-data/binary.cpio", EncodingTypeBinaryLE)
 }
+
+func TestWriterComputeChecksum(t *testing.T) {
+	data, err := ioutil.ReadFile("test-data/ascii-svr4-crc.cpio")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(chunk int) []byte {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		w.ComputeChecksum = true
+		err := w.WriteHeader(&Header{
+			Encoding: EncodingTypeASCIISVR4CRC,
+			DevMinor: 44,
+			Inode:    1337,
+			UID:      1000,
+			GID:      1000,
+			NLink:    1,
+			Mode:     33204,
+			Size:     6,
+			Name:     "hello.txt",
+			ModTime:  time.Unix(1337, 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := []byte("world\n")
+		for len(body) > 0 {
+			n := chunk
+			if n > len(body) {
+				n = len(body)
+			}
+			_, err = w.Write(body[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = body[n:]
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	full := write(6)
+	if !bytes.Equal(full, data[:len(full)]) {
+		t.Errorf("Bad Output:\nExpected: %s\nActual:   %s", data, full)
+	}
+	if chunked := write(1); !bytes.Equal(chunked, full) {
+		t.Errorf("Bad chunked output:\nExpected: %s\nActual:   %s", full, chunked)
+	}
+}
+
+func TestWriterCheckFileType(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.CheckFileType = true
+
+	dir := &Header{Name: "dir/", Mode: 040755, NLink: 2, Size: 4096, ModTime: testModTime}
+	err := w.WriteHeader(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.Size != 4096 {
+		t.Error("WriteHeader modified the caller's header")
+	}
+
+	err = w.WriteHeader(&Header{Name: "link", Mode: 0120777, NLink: 1, ModTime: testModTime})
+	if err != ErrEmptySymlink {
+		t.Error("expected ErrEmptySymlink but got:", err)
+	}
+	w.Close()
+
+	hdr, err := NewReader(buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "Size", 0, int(hdr.Size))
+}
+
+func TestWriterTrailerModTime(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.TrailerModTime = time.Unix(1337, 0)
+	w.Close()
+
+	// the odc mtime field follows the magic and seven 6-digit fields
+	mtime := string(buf.Bytes()[48:59])
+	if mtime != "00000002471" {
+		t.Errorf("expected trailer mtime to be '%s' but got '%s'", "00000002471", mtime)
+	}
+}
+
+func TestWriterNameTooLong(t *testing.T) {
+	limits := map[EncodingType]int{
+		EncodingTypeASCIISUSv2: 262142,
+		EncodingTypeBinaryLE:   65534,
+	}
+	for enc, limit := range limits {
+		t.Run(enc.String(), func(t *testing.T) {
+			w := NewWriter(ioutil.Discard)
+			hdr := &Header{Encoding: enc, Name: strings.Repeat("a", limit), Mode: 0100644, NLink: 1, ModTime: testModTime}
+			if err := w.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			hdr.Name += "a"
+			if err := w.WriteHeader(hdr); err != ErrNameTooLong {
+				t.Error("expected ErrNameTooLong but got:", err)
+			}
+		})
+	}
+}
+
+func TestWriterTrimDirSlash(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.TrimDirSlash = true
+	w.WriteHeader(&Header{Name: "dir/", Mode: 040755, NLink: 2, ModTime: testModTime})
+	w.WriteHeader(&Header{Name: "file/", Mode: 0100644, NLink: 1, ModTime: testModTime})
+	w.Close()
+
+	r := NewReader(buf)
+	for _, name := range []string{"dir", "file/"} {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected Name to be '%s' but got '%s'", name, hdr.Name)
+		}
+	}
+}
+
+func TestWriterAddReader(t *testing.T) {
+	hdr := &Header{Name: "hello.txt", Mode: 0100644, NLink: 1, Size: 6, ModTime: testModTime}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	if err := w.AddReader(hdr, strings.NewReader("world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := testArchive(t, EncodingTypeASCIISUSv2, "hello.txt", "world\n")
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Bad Output:\nExpected: %s\nActual:   %s", expected, buf.Bytes())
+	}
+
+	w = NewWriter(ioutil.Discard)
+	if err := w.AddReader(hdr, strings.NewReader("world")); err != ErrWriteTooShort {
+		t.Error("expected ErrWriteTooShort but got:", err)
+	}
+	w = NewWriter(ioutil.Discard)
+	if err := w.AddReader(hdr, strings.NewReader("world\n!")); err != ErrWriteTooLong {
+		t.Error("expected ErrWriteTooLong but got:", err)
+	}
+}
+
+func TestWriterWriteHeaderOnly(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	for _, name := range []string{"a/", "b/", "c/"} {
+		err := w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: 040755, NLink: 2, ModTime: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: "file", Mode: 0100644, NLink: 1, Size: 1, ModTime: testModTime})
+	if err == nil {
+		t.Error("expected an error for a non-zero size")
+	}
+	w.Close()
+
+	r := NewReader(buf)
+	for _, name := range []string{"a/", "b/", "c/"} {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected Name to be '%s' but got '%s'", name, hdr.Name)
+		}
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Error("expected io.EOF after last entry but got:", err)
+	}
+}
+
+func TestWriterAutoInode(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AutoInode = true
+	for _, inode := range []int{0, 0, 500, 500, 0} {
+		err := w.WriteHeader(&Header{Encoding: EncodingTypeBinaryLE, Name: "file", Inode: inode, Mode: 0100644, NLink: 1, ModTime: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	r := NewReader(buf)
+	for _, inode := range []int{1, 2, 500, 500, 3} {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		intEq(t, "Inode", inode, hdr.Inode)
+	}
+
+	w = NewWriter(ioutil.Discard)
+	w.AutoInode = true
+	w.inode = 0xffff
+	err := w.WriteHeader(&Header{Encoding: EncodingTypeBinaryLE, Name: "file", Mode: 0100644, NLink: 1, ModTime: testModTime})
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "bytes written by Flush", 0, buf.Len())
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(MagicODC)) {
+		t.Errorf("expected an odc trailer but got %q", buf.Bytes())
+	}
+	n := buf.Len()
+	if err := w.Close(); err != nil {
+		t.Error("second Close:", err)
+	}
+	intEq(t, "bytes written by second Close", n, buf.Len())
+	if err := w.WriteHeader(&Header{Name: "late"}); err != ErrWriteAfterClose {
+		t.Error("expected ErrWriteAfterClose but got:", err)
+	}
+	if _, err := NewReader(buf).Next(); err != io.EOF {
+		t.Error("expected io.EOF for an empty archive but got:", err)
+	}
+
+	buf.Reset()
+	w = NewWriterEncoding(buf, EncodingTypeASCIISVR4)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(MagicNewc)) {
+		t.Errorf("expected a newc trailer but got %q", buf.Bytes())
+	}
+}
+
+func TestWriterModeRoundTrip(t *testing.T) {
+	// unknown file type bits along with every permission bit
+	const exotic = 0177777
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			src := &Header{Encoding: enc, Name: "exotic", Mode: exotic, NLink: 1, ModTime: testModTime}
+			hdr, err := FileInfoHeader(src.FileInfo())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			if err = w.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+
+			hdr, err = NewReader(buf).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			intEq(t, "Mode", exotic, int(hdr.Mode))
+		})
+	}
+
+	w := NewWriter(ioutil.Discard)
+	err := w.WriteHeader(&Header{Encoding: EncodingTypeBinaryLE, Name: "wide", Mode: 0200000 | exotic, ModTime: testModTime})
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+}
+
+// flakyFile fails writes while fail is set
+type flakyFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *flakyFile) Write(b []byte) (int, error) {
+	if f.fail {
+		return 0, errors.New("flaky write")
+	}
+	return f.File.Write(b)
+}
+
+func TestWriterRewind(t *testing.T) {
+	fd, err := ioutil.TempFile("", "cpio-rewind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	f := &flakyFile{File: fd}
+	w := NewWriter(f)
+	w.BuildIndex = true
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: "a", Mode: 0100644, NLink: 1, Size: 5, ModTime: testModTime}
+	if err = w.AddReader(hdr, strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	good := w.LastGoodOffset()
+	intEq(t, "LastGoodOffset", HeaderSizeNewc+2+8, int(good))
+
+	hdr = &Header{Encoding: EncodingTypeASCIISVR4, Name: "b", Mode: 0100644, NLink: 1, Size: 6, ModTime: testModTime}
+	if err = w.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "sec")
+	f.fail = true
+	if _, err = io.WriteString(w, "ond"); err == nil {
+		t.Fatal("expected a write error")
+	}
+	intEq(t, "LastGoodOffset after failure", int(good), int(w.LastGoodOffset()))
+
+	f.fail = false
+	if err = w.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "index entries", 1, len(w.Index()))
+	if err = w.AddReader(hdr, strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Rewind(); err != ErrWriteAfterClose {
+		t.Error("expected ErrWriteAfterClose but got:", err)
+	}
+
+	fd.Seek(0, io.SeekStart)
+	r := NewReader(fd)
+	for _, name := range []string{"a", "b"} {
+		hdr, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected name to be %s but got %s", name, hdr.Name)
+		}
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Error("expected io.EOF but got:", err)
+	}
+
+	if err = NewWriter(new(bytes.Buffer)).Rewind(); err != ErrRewindUnsupported {
+		t.Error("expected ErrRewindUnsupported but got:", err)
+	}
+}
+
+func TestWriterIDShift(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.IDShift = IDShift{UID: 100000, GID: 200000}
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: "file", Mode: 0100644, UID: 5, GID: 6, NLink: 1, ModTime: testModTime}
+	if err := w.WriteHeaderOnly(hdr); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "caller's UID", 5, hdr.UID)
+	w.Close()
+
+	got, err := NewReader(buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "UID", 100005, got.UID)
+	intEq(t, "GID", 200006, got.GID)
+
+	w = NewWriter(ioutil.Discard)
+	w.IDShift = IDShift{UID: 100000}
+	hdr.Encoding = EncodingTypeBinaryLE
+	if err = w.WriteHeader(hdr); !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+
+	w = NewWriter(ioutil.Discard)
+	w.IDShift = IDShift{GID: -7}
+	if err = w.WriteHeader(hdr); !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow for a negative GID but got:", err)
+	}
+}
+
+func TestWriterCloseShort(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteHeader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "short", Mode: 0100644, NLink: 1, Size: 10, ModTime: testModTime})
+	io.WriteString(w, "abc")
+	n := buf.Len()
+
+	if err := w.Close(); err != ErrWriteTooShort {
+		t.Error("expected ErrWriteTooShort but got:", err)
+	}
+	intEq(t, "bytes written by Close", n, buf.Len())
+	if strings.Contains(buf.String(), "TRAILER!!!") {
+		t.Error("expected no trailer after a short entry")
+	}
+
+	w = NewWriter(ioutil.Discard)
+	w.WriteHeader(&Header{Name: "short", Mode: 0100644, NLink: 1, Size: 10, ModTime: testModTime})
+	if err := w.Flush(); !errors.Is(err, ErrWriteTooShort) {
+		t.Error("expected Flush to return ErrWriteTooShort but got:", err)
+	}
+}
+
+func TestWriterPermMask(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.PermMask = 0755
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: "file", Mode: 0104666, NLink: 1, ModTime: testModTime}
+	w.WriteHeaderOnly(hdr)
+	w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: "dir", Mode: 040700, NLink: 1, ModTime: testModTime})
+	w.Close()
+	intEq(t, "caller's Mode", 0104666, int(hdr.Mode))
+
+	r := NewReader(buf)
+	for _, mode := range []int{0100644, 040700} {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		intEq(t, got.Name+" Mode", mode, int(got.Mode))
+	}
+}
+
+// countingWriter counts the calls to Write
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(b)
+}
+
+func TestNewWriterSize(t *testing.T) {
+	dst := new(countingWriter)
+	w := NewWriterSize(dst, 4096)
+	for _, name := range []string{"a", "b", "c"} {
+		err := w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: 0100644, NLink: 1, Size: 3, ModTime: testModTime}, strings.NewReader("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	intEq(t, "writes before Flush", 0, dst.writes)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "writes after Flush", 1, dst.writes)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "writes after Close", 2, dst.writes)
+
+	expected := testArchive(t, EncodingTypeASCIISVR4, "a", "abc", "b", "abc", "c", "abc")
+	if !bytes.Equal(expected, dst.Bytes()) {
+		t.Errorf("Bad Output:\nExpected: %q\nActual:   %q", expected, dst.Bytes())
+	}
+}
+
+func TestWriterNewcChecksum(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: "file", Mode: 0100644, NLink: 1, ModTime: testModTime, Checksum: 562})
+	if field := buf.String()[102:110]; field != "00000000" {
+		t.Errorf("expected checksum field to be 00000000 but got %s", field)
+	}
+}
+
+func TestWriterWriteSection(t *testing.T) {
+	src := strings.NewReader("hello, world")
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: "hello", Mode: 0100644, NLink: 1, Size: 5, ModTime: testModTime}
+	if err := w.WriteSection(hdr, src, 7, 5); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r := NewReader(buf)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" {
+		t.Errorf("expected data to be world but got %q", data)
+	}
+
+	w = NewWriter(ioutil.Discard)
+	if err := w.WriteSection(hdr, src, 0, 4); err == nil {
+		t.Error("expected an error for a mismatched length")
+	}
+	if err := w.WriteSection(hdr, src, 10, 5); err != ErrWriteTooShort {
+		t.Error("expected ErrWriteTooShort but got:", err)
+	}
+}
+
+func TestWriterZeroModTime(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			if err := w.WriteHeaderOnly(&Header{Encoding: enc, Name: "file", Mode: 0100644, NLink: 1}); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+
+			hdr, err := NewReader(buf).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			intEq(t, "ModTime", 0, int(hdr.ModTime.Unix()))
+		})
+	}
+}
+
+func TestWriterWriteGenerated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: "etc/hostname", Mode: 0100644, NLink: 1, Size: 5, ModTime: testModTime}
+	err := w.WriteGenerated(hdr, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "host%d", 1)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if !bytes.Equal(buf.Bytes(), testArchive(t, EncodingTypeASCIISVR4, "etc/hostname", "host1")) {
+		t.Errorf("expected generated entry to match a written one but got %q", buf.Bytes())
+	}
+
+	w = NewWriter(ioutil.Discard)
+	err = w.WriteGenerated(hdr, func(w io.Writer) error {
+		io.WriteString(w, "too long")
+		return nil
+	})
+	if !errors.Is(err, ErrWriteTooLong) {
+		t.Error("expected ErrWriteTooLong but got:", err)
+	}
+
+	w = NewWriter(ioutil.Discard)
+	err = w.WriteGenerated(hdr, func(w io.Writer) error {
+		_, err := io.WriteString(w, "abc")
+		return err
+	})
+	if !errors.Is(err, ErrWriteTooShort) {
+		t.Error("expected ErrWriteTooShort but got:", err)
+	}
+
+	genErr := errors.New("generator failed")
+	w = NewWriter(ioutil.Discard)
+	if err = w.WriteGenerated(hdr, func(io.Writer) error { return genErr }); err != genErr {
+		t.Error("expected generator error but got:", err)
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	events := make(chan ProgressEvent, 10)
+	w := NewWriter(ioutil.Discard)
+	w.Progress = events
+	w.ComputeChecksum = true
+	w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4CRC, Name: "a", Mode: 0100644, NLink: 1, Size: 5, ModTime: testModTime}, strings.NewReader("hello"))
+	w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4CRC, Name: "dir", Mode: 040755, NLink: 2, ModTime: testModTime})
+	w.WriteHeader(&Header{Encoding: EncodingTypeASCIISVR4CRC, Name: "b", Mode: 0100644, NLink: 1, Size: 2, ModTime: testModTime})
+	io.WriteString(w, "hi")
+	if len(events) != 2 {
+		t.Errorf("expected 2 events before b is finished but got %d", len(events))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+
+	expected := []ProgressEvent{
+		{Name: "a", Size: 5, Entries: 1, DataBytes: 5, ArchiveBytes: 120},
+		{Name: "dir", Size: 0, Entries: 2, DataBytes: 5, ArchiveBytes: 236},
+		{Name: "b", Size: 2, Entries: 3, DataBytes: 7, ArchiveBytes: 352},
+	}
+	var i int
+	for e := range events {
+		if i >= len(expected) {
+			t.Errorf("unexpected event %+v", e)
+			continue
+		}
+		if e != expected[i] {
+			t.Errorf("expected event %+v but got %+v", expected[i], e)
+		}
+		i++
+	}
+	intEq(t, "events", len(expected), i)
+
+	// a full channel does not block the writer
+	w = NewWriter(ioutil.Discard)
+	w.Progress = make(chan ProgressEvent)
+	if err := w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "a", Mode: 0100644, NLink: 1, Size: 1, ModTime: testModTime}, strings.NewReader("x")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriterAutoDirs(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AutoDirs = true
+	write := func(name string, mode int64) {
+		if err := w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: name, Mode: mode, NLink: 1, ModTime: testModTime}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("etc/", 040700)
+	write("usr/lib/foo.so", 0100755)
+	write("usr/lib/bar.so", 0100755)
+	write("./etc/ssl/certs/ca.pem", 0100644)
+	write("usr//share/doc", 0100644)
+	w.Close()
+
+	var got []string
+	inodes := make(map[int]string)
+	r := NewReader(buf)
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s:%o", hdr.Name, hdr.Mode))
+		if hdr.Mode&^07777 == modeDirectory && hdr.Name != "etc/" {
+			// readers such as libarchive take directories sharing an
+			// inode for hard links of each other
+			if other, ok := inodes[hdr.Inode]; ok || hdr.Inode == 0 {
+				t.Errorf("%s: expected a unique inode but got %d, as %s", hdr.Name, hdr.Inode, other)
+			}
+			inodes[hdr.Inode] = hdr.Name
+		}
+		if !hdr.ModTime.Equal(testModTime) {
+			t.Errorf("%s: expected ModTime to be %v but got %v", hdr.Name, testModTime, hdr.ModTime)
+		}
+	}
+	expected := "etc/:40700,usr:40755,usr/lib:40755,usr/lib/foo.so:100755,usr/lib/bar.so:100755," +
+		"./etc/ssl:40755,./etc/ssl/certs:40755,./etc/ssl/certs/ca.pem:100644,usr/share:40755,usr//share/doc:100644"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("expected %s but got %s", expected, strings.Join(got, ","))
+	}
+}
+
+func TestWriterAutoDirsInvalid(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AutoDirs = true
+
+	// the entry is rejected, so its parents must not be written either
+	err := w.WriteHeaderOnly(&Header{Encoding: EncodingTypeBinaryLE, Name: "a/b/file", Mode: 0100644, UID: 70000, ModTime: testModTime})
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+	w.CheckFileType = true
+	err = w.WriteHeaderOnly(&Header{Encoding: EncodingTypeBinaryLE, Name: "a/link", Mode: 0120777, ModTime: testModTime})
+	if err != ErrEmptySymlink {
+		t.Error("expected ErrEmptySymlink but got:", err)
+	}
+	if err = w.WriteHeaderOnly(&Header{Encoding: EncodingTypeBinaryLE, Name: "d/file", Mode: 0100644, ModTime: testModTime}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	hdrs, err := NewReader(buf).Headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hdr := range hdrs {
+		got = append(got, hdr.Name)
+	}
+	expected := "d,d/file"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("expected %s but got %s", expected, strings.Join(got, ","))
+	}
+}
+
+func TestWriterAutoDirsFailed(t *testing.T) {
+	fd, err := ioutil.TempFile("", "cpio-autodirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	f := &flakyFile{File: fd}
+	w := NewWriter(f)
+	w.AutoDirs = true
+
+	// a directory that can't be written is still needed by its contents
+	err = w.WriteHeaderOnly(&Header{Encoding: EncodingTypeBinaryLE, Name: "big", Mode: 040755, UID: 70000, ModTime: testModTime})
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Error("expected ErrFieldOverflow but got:", err)
+	}
+	if err = w.WriteHeaderOnly(&Header{Encoding: EncodingTypeBinaryLE, Name: "big/file", Mode: 0100644, ModTime: testModTime}); err != nil {
+		t.Fatal(err)
+	}
+
+	// as is one discarded by Rewind
+	if err = w.WriteHeader(&Header{Encoding: EncodingTypeBinaryLE, Name: "lost", Mode: 040755, Size: 4, ModTime: testModTime}); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "ab")
+	f.fail = true
+	if _, err = io.WriteString(w, "cd"); err == nil {
+		t.Fatal("expected a write error")
+	}
+	f.fail = false
+	if err = w.Rewind(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteHeaderOnly(&Header{Encoding: EncodingTypeBinaryLE, Name: "lost/file", Mode: 0100644, ModTime: testModTime}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fd.Seek(0, io.SeekStart)
+	var got []string
+	hdrs, err := NewReader(fd).Headers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hdr := range hdrs {
+		got = append(got, hdr.Name)
+	}
+	expected := "big,big/file,lost,lost/file"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("expected %s but got %s", expected, strings.Join(got, ","))
+	}
+}
+
+func TestWriterDefaultNLink(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		w := NewWriter(buf)
+		w.KeepZeroNLink = keep
+		w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: "dir", Mode: 040755, ModTime: testModTime})
+		w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: "file", Mode: 0100644, ModTime: testModTime})
+		w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4, Name: "link", Mode: 0100644, NLink: 3, ModTime: testModTime})
+		w.Close()
+
+		expected := []int{2, 1, 3}
+		if keep {
+			expected = []int{0, 0, 3}
+		}
+		r := NewReader(buf)
+		for _, nlink := range expected {
+			hdr, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			intEq(t, hdr.Name+" NLink", nlink, hdr.NLink)
+		}
+	}
+}
+
+func TestWriterWriteStream(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.MaxBufferBytes = 8
+	for _, body := range []string{"small", "larger than the buffer"} {
+		hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: body, Mode: 0100644, NLink: 1, Size: 1, ModTime: testModTime}
+		if err := w.WriteStream(hdr, strings.NewReader(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	expected := testArchive(t, EncodingTypeASCIISVR4, "small", "small", "larger than the buffer", "larger than the buffer")
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Bad Output:\nExpected: %q\nActual:   %q", expected, buf.Bytes())
+	}
+	if entries, _ := ioutil.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("expected temporary files to be removed but found %d", len(entries))
+	}
+}
+
+func TestWriterWriteStreamChecksum(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	body := strings.Repeat("larger than the buffer\n", 100)
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.ComputeChecksum = true
+	w.MaxBufferBytes = 8
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4CRC, Name: "file", Mode: 0100644, NLink: 1, ModTime: testModTime}
+	if err := w.WriteStream(hdr, strings.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	// the data went to the temporary file only, not the checksum buffer
+	if w.crcBuf.Cap() != 0 {
+		t.Errorf("expected the checksum buffer to be unused but it holds %d bytes", w.crcBuf.Cap())
+	}
+	w.Close()
+
+	r := NewReader(buf)
+	got, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Checksum != Checksum([]byte(body)) {
+		t.Errorf("expected Checksum to be %#x but got %#x", Checksum([]byte(body)), got.Checksum)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != body {
+		t.Error("expected the data to be written unchanged")
+	}
+}
+
+func TestWriterHighChecksum(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.WriteHeaderOnly(&Header{Encoding: EncodingTypeASCIISVR4CRC, Name: "file", Mode: 0100644, NLink: 1, ModTime: testModTime, Checksum: 0xfedcba98})
+	w.Close()
+	if field := buf.String()[102:110]; field != "FEDCBA98" {
+		t.Errorf("expected checksum field to be FEDCBA98 but got %s", field)
+	}
+
+	hdr, err := NewReader(buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Checksum != 0xfedcba98 {
+		t.Errorf("expected Checksum to be 0xfedcba98 but got %#x", hdr.Checksum)
+	}
+}
+
+func TestWriterDeviceRoundTrip(t *testing.T) {
+	for _, enc := range []EncodingType{EncodingTypeASCIISUSv2, EncodingTypeASCIISVR4, EncodingTypeASCIISVR4CRC, EncodingTypeBinaryLE, EncodingTypeBinaryBE} {
+		t.Run(enc.String(), func(t *testing.T) {
+			src := NewBlockDeviceHeader("dev/sda", 8, 0, 0660)
+			src.Encoding = enc
+			src.ModTime = testModTime
+
+			buf := new(bytes.Buffer)
+			w := NewWriter(buf)
+			if err := w.WriteHeaderOnly(src); err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+
+			hdr, err := NewReader(buf).Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			intEq(t, "Mode", 060660, int(hdr.Mode))
+			intEq(t, "RDevMajor", 8, hdr.RDevMajor)
+			intEq(t, "RDevMinor", 0, hdr.RDevMinor)
+
+			// minor numbers wider than 8 bits only fit in newc and crc
+			src.RDevMinor = 300
+			err = NewWriter(ioutil.Discard).WriteHeader(src)
+			if wide := enc == EncodingTypeASCIISVR4 || enc == EncodingTypeASCIISVR4CRC; wide && err != nil {
+				t.Error("unexpected error:", err)
+			} else if !wide && !errors.Is(err, ErrFieldOverflow) {
+				t.Error("expected ErrFieldOverflow but got:", err)
+			}
+		})
+	}
+}
+
+func TestWriterCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		w := NewWriter(pw)
+		w.WriteHeader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "partial", Mode: 0100644, NLink: 1, Size: 10, ModTime: testModTime})
+		io.WriteString(w, "abc")
+		w.Cancel()
+		if err := w.WriteHeader(&Header{Name: "late"}); err != ErrWriteAfterClose {
+			t.Error("expected ErrWriteAfterClose but got:", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Error("Close after Cancel:", err)
+		}
+	}()
+
+	r := NewReader(pr)
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrCanceled {
+		t.Error("expected ErrCanceled but got:", err)
+	}
+}
+
+func BenchmarkWriterNewc(b *testing.B) {
+	hdrs := make([]Header, 10000)
+	for i := range hdrs {
+		hdrs[i] = Header{Encoding: EncodingTypeASCIISVR4, Name: fmt.Sprintf("dir/file%05d", i), Mode: 0100644, NLink: 1, Inode: i + 1, ModTime: testModTime}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := NewWriterSize(ioutil.Discard, 65536)
+		for j := range hdrs {
+			if err := w.WriteHeaderOnly(&hdrs[j]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		w.Close()
+	}
+}
+
+func TestWriterClampModTime(t *testing.T) {
+	hdr := &Header{Encoding: EncodingTypeASCIISVR4, Name: "old", Mode: 0100644, ModTime: time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	w := NewWriter(ioutil.Discard)
+	err := w.WriteHeader(hdr)
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Errorf("expected ErrFieldOverflow for a 1960 mtime but got: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	w = NewWriter(buf)
+	w.ClampModTime = true
+	err = w.WriteHeader(hdr)
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	w.Close()
+	got, err := NewReader(buf).Next()
+	if err != nil {
+		t.Fatalf("expected no error but got: %v", err)
+	}
+	if got.ModTime.Unix() != 0 {
+		t.Errorf("expected mtime to be clamped to the epoch but got %v", got.ModTime)
+	}
+}
+
+func TestWriterSetTrailerEncoding(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.SetTrailerEncoding(EncodingTypeASCIISVR4)
+	if err := w.AddReader(&Header{Encoding: EncodingTypeASCIISUSv2, Name: "a", Mode: 0100644, Size: 1}, strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte(MagicODC)) {
+		t.Errorf("expected the entry to be odc but got %q", out[:6])
+	}
+	trailer := out[HeaderSizeODC+2+1:]
+	if !bytes.HasPrefix(trailer, []byte(MagicNewc)) || !bytes.Contains(trailer, []byte("TRAILER!!!")) {
+		t.Errorf("expected a newc trailer but got %q", trailer)
+	}
+	if _, err := NewReader(buf).Headers(); err != nil {
+		t.Errorf("expected the archive to read back but got: %v", err)
+	}
+}
+
+func TestWriterFieldOverflow(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  Header
+	}{
+		{"newc size", Header{Encoding: EncodingTypeASCIISVR4, Size: 1<<32 + 1}},
+		{"newc inode", Header{Encoding: EncodingTypeASCIISVR4, Inode: -1}},
+		{"newc dev major", Header{Encoding: EncodingTypeASCIISVR4, DevMajor: -1}},
+		{"newc dev minor", Header{Encoding: EncodingTypeASCIISVR4, DevMinor: -1}},
+		{"crc rdev major", Header{Encoding: EncodingTypeASCIISVR4CRC, RDevMajor: -1}},
+		{"crc rdev minor", Header{Encoding: EncodingTypeASCIISVR4CRC, RDevMinor: -1}},
+		{"odc size", Header{Encoding: EncodingTypeASCIISUSv2, Size: 1 << 33}},
+		{"odc mtime", Header{Encoding: EncodingTypeASCIISUSv2, ModTime: time.Unix(1<<33, 0)}},
+		{"odc nlink", Header{Encoding: EncodingTypeASCIISUSv2, NLink: 01000000}},
+		{"binary uid", Header{Encoding: EncodingTypeBinaryLE, UID: 70000}},
+		{"binary gid", Header{Encoding: EncodingTypeBinaryBE, GID: -1}},
+		{"binary mtime", Header{Encoding: EncodingTypeBinaryLE, ModTime: time.Unix(1<<32, 0)}},
+		{"binary size", Header{Encoding: EncodingTypeBinaryLE, Size: 1 << 32}},
+	}
+	for _, test := range tests {
+		hdr := test.hdr
+		hdr.Name = "f"
+		hdr.Mode = 0100644
+
+		err := NewWriter(ioutil.Discard).WriteHeader(&hdr)
+		if !errors.Is(err, ErrFieldOverflow) {
+			t.Errorf("%s: expected ErrFieldOverflow but got: %v", test.name, err)
+		}
+	}
+
+	// device numbers past 32 bits only fit in a 64-bit int
+	if strconv.IntSize == 64 {
+		var wide int64 = 1 << 32
+		big := int(wide)
+		for _, hdr := range []Header{{DevMajor: big}, {DevMinor: big}, {RDevMajor: big}, {RDevMinor: big}} {
+			hdr.Encoding = EncodingTypeASCIISVR4
+			hdr.Name = "f"
+			hdr.Mode = 0100644
+			err := NewWriter(ioutil.Discard).WriteHeader(&hdr)
+			if !errors.Is(err, ErrFieldOverflow) {
+				t.Errorf("expected ErrFieldOverflow for dev %d, %d rdev %d, %d but got: %v", hdr.DevMajor, hdr.DevMinor, hdr.RDevMajor, hdr.RDevMinor, err)
+			}
+		}
+	}
+}
+
+func TestWriterBlockSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewWriterEncoding(buf, EncodingTypeASCIISVR4)
+	w.BlockSize = 512
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	trailer := "070701" + "00000000" + "00000000" + "00000000" + "00000000" +
+		"00000001" + "00000000" + "00000000" + "00000000" + "00000000" +
+		"00000000" + "00000000" + "0000000B" + "00000000" + "TRAILER!!!\x00\x00\x00\x00"
+	intEq(t, "archive size", 512, buf.Len())
+	out := buf.Bytes()
+	if !bytes.HasPrefix(out, []byte(trailer)) {
+		t.Errorf("Bad Output:\nExpected: %q\nActual:   %q", trailer, out[:len(trailer)])
+	}
+	if !isZero(out[len(trailer):]) {
+		t.Error("expected only block padding after the trailer")
+	}
+}
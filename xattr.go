@@ -0,0 +1,98 @@
+package cpio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// XattrPrefix begins the name of entries written by WriteXattrs.
+const XattrPrefix = ".xattr/"
+
+// ErrXattrFormat is returned by DecodeXattrs for malformed xattr data.
+var ErrXattrFormat = errors.New("cpio: malformed xattr entry")
+
+// WriteXattrs writes the extended attributes of target as a companion
+// regular file entry named XattrPrefix followed by target, in the encoding of
+// the archive's earlier entries, or odc if there are none. It is written like
+// any other entry, so it must not be called while a file's data is pending.
+//
+// The entry's data holds each attribute in order of name, as a 4 byte
+// big-endian name length, the name, a 4 byte big-endian value length and
+// the value. Readers use XattrTarget and DecodeXattrs to recover them.
+func (cw *Writer) WriteXattrs(target string, attrs map[string][]byte) error {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	var n [4]byte
+	for _, name := range names {
+		binary.BigEndian.PutUint32(n[:], uint32(len(name)))
+		buf.Write(n[:])
+		buf.WriteString(name)
+		binary.BigEndian.PutUint32(n[:], uint32(len(attrs[name])))
+		buf.Write(n[:])
+		buf.Write(attrs[name])
+	}
+
+	return cw.AddReader(&Header{
+		Encoding: cw.enc,
+		Name:     XattrPrefix + target,
+		Mode:     0100600,
+		NLink:    1,
+		ModTime:  time.Unix(0, 0),
+		Size:     int64(buf.Len()),
+	}, &buf)
+}
+
+// XattrTarget reports whether hdr is an entry written by WriteXattrs, and
+// if so the name of the entry its attributes belong to.
+func XattrTarget(hdr *Header) (string, bool) {
+	if hdr.Mode&^07777 != modeRegular || !strings.HasPrefix(hdr.Name, XattrPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(hdr.Name, XattrPrefix), true
+}
+
+// DecodeXattrs decodes the data of an entry written by WriteXattrs.
+func DecodeXattrs(r io.Reader) (map[string][]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(map[string][]byte)
+	for len(data) > 0 {
+		name, rest, ok := xattrField(data)
+		if !ok {
+			return nil, ErrXattrFormat
+		}
+		value, rest, ok := xattrField(rest)
+		if !ok {
+			return nil, ErrXattrFormat
+		}
+		attrs[string(name)] = value
+		data = rest
+	}
+	return attrs, nil
+}
+
+// xattrField splits a length-prefixed field from the front of b
+func xattrField(b []byte) (field, rest []byte, ok bool) {
+	if len(b) < 4 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint64(n) > uint64(len(b)) {
+		return nil, nil, false
+	}
+	return b[:n], b[n:], true
+}
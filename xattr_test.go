@@ -0,0 +1,57 @@
+package cpio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestXattrs(t *testing.T) {
+	attrs := map[string][]byte{
+		"security.selinux": []byte("system_u:object_r:bin_t:s0\x00"),
+		"user.empty":       {},
+	}
+
+	buf := new(bytes.Buffer)
+	w := NewWriter(buf)
+	w.AddReader(&Header{Encoding: EncodingTypeASCIISVR4, Name: "bin/sh", Mode: 0100755, NLink: 1, Size: 2, ModTime: testModTime}, strings.NewReader("sh"))
+	if err := w.WriteXattrs("bin/sh", attrs); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	r := NewReader(buf)
+	hdr, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := XattrTarget(hdr); ok {
+		t.Error("expected a regular entry not to be an xattr entry")
+	}
+
+	hdr, err = r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Encoding != EncodingTypeASCIISVR4 {
+		t.Errorf("expected the xattr entry to be newc but got %s", hdr.Encoding)
+	}
+	target, ok := XattrTarget(hdr)
+	if !ok || target != "bin/sh" {
+		t.Fatalf("expected xattrs for bin/sh but got %q, %v", target, ok)
+	}
+	got, err := DecodeXattrs(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intEq(t, "attrs", len(attrs), len(got))
+	for name, value := range attrs {
+		if !bytes.Equal(got[name], value) {
+			t.Errorf("expected %s to be %q but got %q", name, value, got[name])
+		}
+	}
+
+	if _, err = DecodeXattrs(bytes.NewReader([]byte{0, 0, 0, 9, 'x'})); err != ErrXattrFormat {
+		t.Error("expected ErrXattrFormat but got:", err)
+	}
+}